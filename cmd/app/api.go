@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brchri/tesla-geogdo/internal/geo"
+	"github.com/brchri/tesla-geogdo/internal/history"
+	logger "github.com/sirupsen/logrus"
+)
+
+// number of decisions returned per tracker if no limit is requested
+const defaultDecisionsLimit = 10
+
+// returns recorded events as json, filtered by the optional door, tracker, action, type, since, until, and limit
+// query parameters; since and until are RFC 3339 timestamps, e.g. 2024-01-02T15:04:05Z
+func apiHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := history.Filter{
+		Door:    query.Get("door"),
+		Tracker: query.Get("tracker"),
+		Action:  query.Get("action"),
+		Type:    query.Get("type"),
+	}
+	var err error
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			http.Error(w, "Invalid since parameter, expected RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			http.Error(w, "Invalid until parameter, expected RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, history.Query(filter))
+}
+
+// returns the most recent geofence evaluations for each tracker as json, explaining why actions did or didn't
+// execute; filtered by the optional door and tracker query parameters, and limited to the last `limit` per tracker
+func apiDecisionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultDecisionsLimit
+	if l := query.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, geo.Decisions(query.Get("door"), query.Get("tracker"), limit))
+}
+
+// returns the status of each garage door as json, filtered by the optional door query parameter
+func apiDoorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, geo.DoorStatuses(r.URL.Query().Get("door")))
+}
+
+// returns the status of each tracker as json, filtered by the optional door and tracker query parameters
+func apiTrackersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	writeJSON(w, http.StatusOK, geo.TrackerStatuses(query.Get("door"), query.Get("tracker")))
+}
+
+// operates a garage door on request, e.g. `POST /doors/0/open`, through the same path as tracker actions; responds
+// with the command once the opener returns, or immediately with the pending command if the async query parameter
+// is true, whose status can then be polled with apiCommandHandler
+func apiDoorCommandHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	g := geo.FindGarageDoor(r.PathValue("door"))
+	if g == nil {
+		http.Error(w, "Garage door not found", http.StatusNotFound)
+		return
+	}
+	action := r.PathValue("action")
+	if action != geo.ActionOpen && action != geo.ActionClose {
+		http.Error(w, "Invalid action, expected open or close", http.StatusBadRequest)
+		return
+	}
+	async, _ := strconv.ParseBool(r.URL.Query().Get("async"))
+
+	c, err := g.SendCommand(action)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if async {
+		writeJSON(w, http.StatusAccepted, c.Snapshot())
+		return
+	}
+	select {
+	case <-c.Done():
+	case <-r.Context().Done():
+		return // client went away, the command still completes and can be queried by its id
+	}
+	result := c.Snapshot()
+	status := http.StatusOK
+	if result.Status == geo.CommandFailed {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, result)
+}
+
+// returns the status of a command sent with apiDoorCommandHandler by its id, e.g. `GET /commands/<id>`
+func apiCommandHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c, ok := geo.FindCommand(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Command not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// wraps the handler so requests are rejected unless they include the token as an `Authorization: Bearer <token>` header
+func requireToken(token string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// writes the value to the response as json with the status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Debugf("Unable to write api response, received error: %v", err)
+	}
+}
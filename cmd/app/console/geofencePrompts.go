@@ -71,27 +71,27 @@ func runTeslamateGeofencePrompts() interface{} {
 		Type: "geofence",
 	}
 
-	geofence.Settings.Close.From = promptUser(question{
+	if from := promptUser(question{
 		prompt:             "Which teslamate geofence should you be leaving to trigger a garage close event (leave blank to skip automatically closing garage)? []",
 		validResponseRegex: ".*",
-	})
-	if len(geofence.Settings.Close.From) > 0 {
-		geofence.Settings.Close.To = promptUser(question{
+	}); len(from) > 0 {
+		geofence.Settings.Close.From = geo.StateValues{from}
+		geofence.Settings.Close.To = geo.StateValues{promptUser(question{
 			prompt:                 "Which teslamate geofence should you be entering to trigger a garage close event?",
 			validResponseRegex:     ".+",
 			invalidResponseMessage: "Please enter a teslamate geofence name",
-		})
+		})}
 	}
-	geofence.Settings.Open.From = promptUser(question{
+	if from := promptUser(question{
 		prompt:             "Which teslamate geofence should you be leaving to trigger a garage open event (leave blank to skip automatically opening garage)? []",
 		validResponseRegex: ".*",
-	})
-	if len(geofence.Settings.Open.From) > 0 {
-		geofence.Settings.Open.To = promptUser(question{
+	}); len(from) > 0 {
+		geofence.Settings.Open.From = geo.StateValues{from}
+		geofence.Settings.Open.To = geo.StateValues{promptUser(question{
 			prompt:                 "Which teslamate geofence should you be entering to trigger a garage open event?",
 			validResponseRegex:     ".+",
 			invalidResponseMessage: "Please enter a teslamate geofence name",
-		})
+		})}
 	}
 
 	fmt.Println("Configuration of teslamate geofence for this garage door is complete, moving on...")
@@ -128,6 +128,7 @@ func runPolygonGeofencePrompts() interface{} {
 
 	// run loop twice, once for open, the other for close
 	var action string
+	var openPoints, closePoints []geo.Point
 	for i := 0; i <= 1; i++ {
 		if i == 0 {
 			action = "open"
@@ -159,9 +160,9 @@ func runPolygonGeofencePrompts() interface{} {
 			p.Lat, _ = strconv.ParseFloat(latResponse, 64)
 			p.Lng, _ = strconv.ParseFloat(lngResponse, 64)
 			if action == "open" {
-				geofence.Settings.Open = append(geofence.Settings.Open, p)
+				openPoints = append(openPoints, p)
 			} else {
-				geofence.Settings.Close = append(geofence.Settings.Close, p)
+				closePoints = append(closePoints, p)
 			}
 			response := promptUser(question{
 				prompt:                 fmt.Sprintf("Would you like to add another point to your polygon %s geofence? [y|n]", action),
@@ -174,6 +175,13 @@ func runPolygonGeofencePrompts() interface{} {
 		}
 	}
 
+	if len(openPoints) > 0 {
+		geofence.Settings.Open = geo.Polygons{{Outer: openPoints}}
+	}
+	if len(closePoints) > 0 {
+		geofence.Settings.Close = geo.Polygons{{Outer: closePoints}}
+	}
+
 	fmt.Println("Configuration of polygon geofence for this garage door is complete, moving on...")
 
 	return geofence
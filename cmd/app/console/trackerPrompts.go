@@ -17,6 +17,9 @@ func runTrackerPrompts() []interface{} {
 			LatJsonKey string `yaml:"lat_json_key,omitempty"`
 			LngJsonKey string `yaml:"lng_json_key,omitempty"`
 		} `yaml:"complex_topic,omitempty"`
+		OwnTracks struct {
+			Topic string `yaml:"topic,omitempty"`
+		} `yaml:"owntracks,omitempty"`
 	}
 
 	asciiArt.NewFigure("Trackers", "", false).Print()
@@ -38,11 +41,16 @@ func runTrackerPrompts() []interface{} {
 			tracker.Id = response
 		}
 		response = promptUser(question{
-			prompt:                 "Does your tracker use simple topics (e.g. basic lat and long values are published to separate topics) or a complex topic (e.g. lat and long are published to the same topic in a json structure)? [s|c]\ns: simple\nc: complex",
-			validResponseRegex:     "^(c|s)$",
-			invalidResponseMessage: "Please enter s (for simple topic) or c (for complex topic)",
+			prompt:                 "Does your tracker use simple topics (e.g. basic lat and long values are published to separate topics), a complex topic (e.g. lat and long are published to the same topic in a json structure), or the OwnTracks app? [s|c|o]\ns: simple\nc: complex\no: owntracks",
+			validResponseRegex:     "^(c|s|o)$",
+			invalidResponseMessage: "Please enter s (for simple topic), c (for complex topic), or o (for owntracks)",
 		})
-		if response == "c" {
+		if response == "o" {
+			tracker.OwnTracks.Topic = promptUser(question{
+				prompt:             "Please enter the owntracks topic for your device (e.g. owntracks/user/phone): ",
+				validResponseRegex: ".+",
+			})
+		} else if response == "c" {
 			tracker.ComplexTopic.Topic = promptUser(question{
 				prompt:             "Please enter the complex topic where lat and long information is published (e.g. my/complex/topic)",
 				validResponseRegex: ".+",
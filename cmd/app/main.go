@@ -18,6 +18,7 @@ import (
 
 	"github.com/brchri/tesla-geogdo/cmd/app/console"
 	"github.com/brchri/tesla-geogdo/internal/geo"
+	"github.com/brchri/tesla-geogdo/internal/history"
 	"github.com/google/uuid"
 	logger "github.com/sirupsen/logrus"
 
@@ -33,7 +34,18 @@ var (
 	commitHash   string
 	messageChan  chan mqtt.Message         // channel to receive mqtt messages
 	mqttSettings *util.MqttConnectSettings // point to util.Config.Global.MqttSettings.Connection for shorter reference
-	pauseChan    chan int                  // handles sending message to goroutine that pauses operations based on api calls
+	commandTopic string                    // topic to receive pause and resume commands on, if any
+	pauseTopic   string                    // topic to publish the pauses in effect to, if any
+)
+
+const (
+	defaultStateFile           = "state.json"
+	defaultStateMaxAge         = 60 // minutes
+	stateSaveInterval          = 30 * time.Second
+	defaultHistoryFile         = "history.jsonl"
+	defaultHistoryMaxAge       = 30 // days
+	defaultHistoryMaxEvents    = 10000
+	defaultHistoryMaxFixEvents = 10000
 )
 
 func init() {
@@ -47,18 +59,21 @@ func init() {
 	parseArgs()
 	util.LoadConfig(configFile)
 	mqttSettings = &util.Config.Global.MqttSettings.Connection
+	commandTopic = util.Config.Global.MqttSettings.Commands.Topic
+	pauseTopic = util.Config.Global.MqttSettings.Commands.PauseTopic
 	if util.Config.Testing {
 		logger.Warn("TESTING=true, will not execute garage door actions")
 	}
 
 	geo.ParseGarageDoorConfig()
 	checkEnvVars()
+	// restore state and open the history before anything can evaluate trackers or serve the api
+	restoreState()
+	openHistory()
 	for _, garageDoor := range geo.GarageDoors {
 		for _, tracker := range garageDoor.Trackers {
 			tracker.GarageDoor = garageDoor
 			trackers = append(trackers, tracker)
-			tracker.InsidePolyCloseGeo = true // only relevent for polygon geos but won't be used if that's not the geofence type
-			tracker.InsidePolyOpenGeo = true  // only relevent for polygon geos but won't be used if that's not the geofence type
 			// start listening to tracker update location channels
 			go processLocationUpdates(tracker)
 		}
@@ -107,9 +122,17 @@ func parseArgs() {
 func main() {
 
 	// initialize api handlers
-	pauseChan = make(chan int)
 	http.HandleFunc("/pause", apiPauseHandler)
 	http.HandleFunc("/resume", apiPauseHandler)
+	http.HandleFunc("/history", apiHistoryHandler)
+	http.HandleFunc("/decisions", apiDecisionsHandler)
+	http.HandleFunc("/doors", apiDoorsHandler)
+	http.HandleFunc("/trackers", apiTrackersHandler)
+	// operating garage doors is opt in, and requires the configured token
+	if commandSettings := util.Config.Global.Api.Commands; commandSettings.Enabled {
+		http.HandleFunc("/doors/{door}/{action}", requireToken(commandSettings.Token, apiDoorCommandHandler))
+		http.HandleFunc("/commands/{id}", requireToken(commandSettings.Token, apiCommandHandler))
+	}
 	go http.ListenAndServe(":8555", nil)
 
 	messageChan = make(chan mqtt.Message)
@@ -167,6 +190,9 @@ func main() {
 
 	// create a new MQTT client object
 	client := mqtt.NewClient(opts)
+	if pauseTopic != "" {
+		geo.OnPauseChanged(func(pauses []geo.PauseStatus) { publishPauses(client, pauses) })
+	}
 
 	// connect to the MQTT broker
 	logger.Debug("Connecting to MQTT broker")
@@ -184,27 +210,50 @@ func main() {
 	for {
 		select {
 		case message := <-messageChan:
+			if commandTopic != "" && message.Topic() == commandTopic {
+				if message.Retained() {
+					// a retained command would be applied again on every reconnect and restart
+					logger.Warnf("Ignoring retained message on command topic %s; commands must be published without the retain flag", commandTopic)
+				} else {
+					processPauseCommand(message.Payload())
+				}
+				continue
+			}
+
+			// cache the latest values of condition topics; these may also be tracker topics, so keep processing
+			geo.UpdateConditionTopic(message.Topic(), message.Payload())
 
 		topic:
 			// check if topic matches any trackers and execute action
 			for _, t := range trackers {
-				var point geo.Point
+				var fix geo.Fix
 				var err error
 				switch message.Topic() {
 				case t.LatTopic:
 					logger.Debugf("Received lat for tracker %v: %s", t.ID, string(message.Payload()))
-					point.Lat, err = strconv.ParseFloat(string(message.Payload()), 64)
+					fix.Lat, err = strconv.ParseFloat(string(message.Payload()), 64)
 				case t.LngTopic:
 					logger.Debugf("Received long for tracker %v: %s", t.ID, string(message.Payload()))
-					point.Lng, err = strconv.ParseFloat(string(message.Payload()), 64)
+					fix.Lng, err = strconv.ParseFloat(string(message.Payload()), 64)
+				case t.HeadingTopic:
+					logger.Debugf("Received heading for tracker %v: %s", t.ID, string(message.Payload()))
+					fix.Course, err = strconv.ParseFloat(string(message.Payload()), 64)
+					fix.HasCourse = err == nil
+				case t.SpeedTopic:
+					logger.Debugf("Received speed for tracker %v: %s", t.ID, string(message.Payload()))
+					fix.Velocity, err = strconv.ParseFloat(string(message.Payload()), 64)
+					fix.HasVelocity = err == nil
 				case t.GeofenceTopic:
-					t.PrevGeofence = t.CurGeofence
-					t.CurGeofence = string(message.Payload())
-					logger.Infof("Received geo for tracker %v: %s", t.ID, t.CurGeofence)
-					go geo.CheckGeofence(t)
+					if fix.State, err = t.ParseStatePayload(message.Payload()); err == nil {
+						logger.Infof("Received geo for tracker %v: %s", t.ID, fix.State)
+						fix.HasState = true
+					}
 				case t.ComplexTopic.Topic:
 					logger.Debugf("Received payload for complex topic %s for tracker %v, payload:\n%s", message.Topic(), t.ID, string(message.Payload()))
-					point, err = processComplexTopicPayload(t, string(message.Payload()))
+					fix.Point, err = processComplexTopicPayload(t, string(message.Payload()))
+				case t.OwnTracks.Topic:
+					logger.Debugf("Received payload for owntracks topic %s for tracker %v, payload:\n%s", message.Topic(), t.ID, string(message.Payload()))
+					fix, err = geo.ParseOwnTracksPayload(message.Payload())
 				default:
 					continue topic // no topic match for this tracker found, move on to next tracker
 				}
@@ -213,12 +262,12 @@ func main() {
 					logger.Errorf("could not parse message payload from topic for tracker %v, received error %v", t.ID, err)
 				}
 
-				// if a point is now defined, process a location update and stop looking for matching topics
-				if point != (geo.Point{}) {
-					go func(p geo.Point, t *geo.Tracker) {
+				// if a point, heading, speed, or state is now defined, process a location update and stop looking for matching topics
+				if fix.Point != (geo.Point{}) || fix.HasCourse || fix.HasVelocity || fix.HasState {
+					go func(f geo.Fix, t *geo.Tracker) {
 						// send as goroutine so it doesn't block other vehicle updates if channel buffer is full
-						t.LocationUpdate <- p
-					}(point, t)
+						t.LocationUpdate <- f
+					}(fix, t)
 				}
 			}
 
@@ -228,6 +277,12 @@ func main() {
 			for _, g := range geo.GarageDoors {
 				g.Opener.ProcessShutdown()
 			}
+			if !util.Config.Global.State.Disabled {
+				if err := geo.SaveState(util.Config.Global.State.File); err != nil {
+					logger.Warn(err)
+				}
+			}
+			history.Close()
 			time.Sleep(250 * time.Millisecond)
 			return
 
@@ -266,19 +321,8 @@ func processComplexTopicPayload(tracker *geo.Tracker, payload string) (geo.Point
 // this allows threaded geofence checks for multiple vehicles, while each individual vehicle
 // does not have parallel threads executing checks
 func processLocationUpdates(tracker *geo.Tracker) {
-	for update := range tracker.LocationUpdate {
-		var newLocation bool
-		if update.Lat != 0 {
-			tracker.CurrentLocation.Lat = update.Lat
-			newLocation = true
-		}
-		if update.Lng != 0 {
-			tracker.CurrentLocation.Lng = update.Lng
-			newLocation = true
-		}
-		if newLocation && tracker.CurrentLocation.IsPointDefined() {
-			geo.CheckGeofence(tracker)
-		}
+	for update := range tracker.PairLocationUpdates() {
+		geo.ProcessFix(tracker, update)
 	}
 }
 
@@ -293,7 +337,10 @@ func onMqttConnect(client mqtt.Client) {
 			tracker.LatTopic,
 			tracker.LngTopic,
 			tracker.GeofenceTopic,
+			tracker.HeadingTopic,
+			tracker.SpeedTopic,
 			tracker.ComplexTopic.Topic,
+			tracker.OwnTracks.Topic,
 		} {
 			if t != "" {
 				topics = append(topics, t)
@@ -302,33 +349,103 @@ func onMqttConnect(client mqtt.Client) {
 
 		// subscribe to topics
 		for _, topic := range topics {
-			topicSubscribed := false
-			// retry topic subscription attempts with 1 sec delay between attempts
-			for retryAttempts := 5; retryAttempts > 0; retryAttempts-- {
-				logger.Debugf("Subscribing to topic: %s", topic)
-				if token := client.Subscribe(
-					topic,
-					0,
-					func(client mqtt.Client, message mqtt.Message) {
-						messageChan <- message
-					}); token.Wait() && token.Error() == nil {
-					topicSubscribed = true
-					logger.Debugf("Topic subscribed successfully: %s", topic)
-					break
-				} else {
-					logger.Infof("Failed to subscribe to topic %s for tracker %v, will make %d more attempts. Error: %v", topic, tracker.ID, retryAttempts, token.Error())
-				}
-				time.Sleep(5 * time.Second)
-			}
-			if !topicSubscribed {
-				logger.Fatalf("Unable to subscribe to topics, exiting")
-			}
+			subscribe(client, topic)
 		}
 	}
 
+	conditionTopics := geo.ConditionTopics()
+	if len(conditionTopics) > 0 {
+		logger.Info("Subscribing to MQTT condition topics")
+	}
+	for _, topic := range conditionTopics {
+		subscribe(client, topic)
+	}
+
+	if commandTopic != "" {
+		logger.Info("Subscribing to MQTT command topic")
+		subscribe(client, commandTopic)
+	}
+	if pauseTopic != "" {
+		// publish the current pauses, as they may have changed while disconnected
+		publishPauses(client, geo.Pauses())
+	}
+
 	logger.Info("Topics subscribed, listening for events...")
 }
 
+// subscribe to a topic, forwarding its messages to messageChan
+func subscribe(client mqtt.Client, topic string) {
+	topicSubscribed := false
+	// retry topic subscription attempts with 1 sec delay between attempts
+	for retryAttempts := 5; retryAttempts > 0; retryAttempts-- {
+		logger.Debugf("Subscribing to topic: %s", topic)
+		if token := client.Subscribe(
+			topic,
+			0,
+			func(client mqtt.Client, message mqtt.Message) {
+				messageChan <- message
+			}); token.Wait() && token.Error() == nil {
+			topicSubscribed = true
+			logger.Debugf("Topic subscribed successfully: %s", topic)
+			break
+		} else {
+			logger.Infof("Failed to subscribe to topic %s, will make %d more attempts. Error: %v", topic, retryAttempts, token.Error())
+		}
+		time.Sleep(5 * time.Second)
+	}
+	if !topicSubscribed {
+		logger.Fatalf("Unable to subscribe to topics, exiting")
+	}
+}
+
+// restores runtime state persisted by a previous run, then starts persisting it periodically
+func restoreState() {
+	stateSettings := &util.Config.Global.State
+	if stateSettings.Disabled {
+		return
+	}
+	if stateSettings.File == "" {
+		stateSettings.File = filepath.Join(filepath.Dir(configFile), defaultStateFile)
+	}
+	if stateSettings.MaxAge <= 0 {
+		stateSettings.MaxAge = defaultStateMaxAge
+	}
+
+	if err := geo.RestoreState(stateSettings.File, time.Duration(stateSettings.MaxAge)*time.Minute); err != nil {
+		logger.Warnf("Unable to restore persisted state, received error: %v", err)
+	}
+	go geo.PersistState(stateSettings.File, stateSaveInterval)
+}
+
+// opens the event history store so events are recorded
+func openHistory() {
+	historySettings := &util.Config.Global.History
+	if historySettings.Disabled {
+		return
+	}
+	if historySettings.File == "" {
+		historySettings.File = filepath.Join(filepath.Dir(configFile), defaultHistoryFile)
+	}
+	if historySettings.MaxAge <= 0 {
+		historySettings.MaxAge = defaultHistoryMaxAge
+	}
+	if historySettings.MaxEvents <= 0 {
+		historySettings.MaxEvents = defaultHistoryMaxEvents
+	}
+	if historySettings.MaxFixEvents <= 0 {
+		historySettings.MaxFixEvents = defaultHistoryMaxFixEvents
+	}
+
+	err := history.Open(historySettings.File, history.Retention{
+		MaxAge:       time.Duration(historySettings.MaxAge) * 24 * time.Hour,
+		MaxEvents:    historySettings.MaxEvents,
+		MaxFixEvents: historySettings.MaxFixEvents,
+	})
+	if err != nil {
+		logger.Warnf("Unable to open event history, events will not be recorded; received error: %v", err)
+	}
+}
+
 // check for env vars and validate that a myq_email and myq_pass exists
 func checkEnvVars() {
 	logger.Debug("Checking environment variables:")
@@ -341,6 +458,13 @@ func checkEnvVars() {
 		logger.Debug("  TRACKER_MQTT_PASS defined, overriding config")
 		mqttSettings.Pass = value
 	}
+	if value, exists := os.LookupEnv("API_COMMANDS_TOKEN"); exists {
+		logger.Debug("  API_COMMANDS_TOKEN defined, overriding config")
+		util.Config.Global.Api.Commands.Token = value
+	}
+	if util.Config.Global.Api.Commands.Enabled && util.Config.Global.Api.Commands.Token == "" {
+		logger.Fatal("global.api.commands.token or API_COMMANDS_TOKEN must be defined when api commands are enabled")
+	}
 	if value, exists := os.LookupEnv("TESTING"); exists {
 		util.Config.Testing, _ = strconv.ParseBool(value)
 		logger.Debugf("  TESTING=%t", util.Config.Testing)
@@ -351,92 +475,58 @@ func checkEnvVars() {
 }
 
 // receives api requests related to pause and resume functions
-// expects GET requests at either the /pause or /resume endpoints
-// and sends to relevant helper functions for processing
+// expects GET requests at either the /pause or /resume endpoints, optionally limited to a
+// garage door or tracker by the door or tracker query parameters, and responds with the pauses in effect
 func apiPauseHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if r.URL.Path == "/resume" {
-		resumeOperations()
-		return
-	}
-
 	query := r.URL.Query()
-	duration := query.Get("duration")
-	var durationInt int
-	if duration != "" && duration != "0" {
-		var err error
-		durationInt, err = strconv.Atoi(duration)
-		if err != nil {
-			http.Error(w, "Invalid duration parameter", http.StatusBadRequest)
-			return
+	scope := geo.PauseScope{Door: query.Get("door"), Tracker: query.Get("tracker")}
+	var err error
+	if r.URL.Path == "/resume" {
+		err = geo.Resume(scope)
+	} else {
+		var duration int
+		if d := query.Get("duration"); d != "" {
+			if duration, err = strconv.Atoi(d); err != nil {
+				http.Error(w, "Invalid duration parameter", http.StatusBadRequest)
+				return
+			}
 		}
+		// no duration pauses indefinitely
+		err = geo.Pause(scope, time.Duration(duration)*time.Second)
 	}
-	pauseOperations(durationInt)
-}
-
-// pauses garage operations either indefinitely or for a finite duration
-// all other processing still functions (e.g. tracking, geofence awareness, etc),
-// only garage operations are disabled
-//
-// if a finite duration is provided, a goroutine will be initiated to wait for the duration
-// to timeout and re-enable garage operations. this goroutine also monitors a go channel
-// in case the pause duration is to be overriden, either by a new duration (finite or infinite),
-// or by a resume command, and behaves accordingly
-func pauseOperations(duration int) {
-	if duration == 0 {
-		duration = -1 // if no duration was defined, set to -1 for infinite pause
-	}
-	if duration > 0 {
-		logger.Infof("Received request to pause operations, pausing for %d seconds, use /resume endpoint to resume garage operations sooner than indicated time", duration)
-	} else {
-		logger.Info("Received request to pause operations indefinitely; use /resume endpoint to resume garage operations")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	writeJSON(w, http.StatusOK, geo.Pauses())
+}
 
-	if util.Config.MasterOpLock > 0 { // if we have a finite lock in progress, send new duration to channel
-		pauseChan <- duration
-		return
+// pauses or resumes garage operations per a command received on the command topic
+func processPauseCommand(payload []byte) {
+	logger.Debugf("Received payload for command topic %s, payload:\n%s", commandTopic, string(payload))
+	command, err := geo.ParsePauseCommand(payload)
+	if err == nil {
+		err = command.Execute()
 	}
-	util.Config.MasterOpLock = duration
-
-	// only set a timeout loop if duration > 0, negatives are infinite pauses
-	if util.Config.MasterOpLock > 0 {
-		go func() {
-			for ; util.Config.MasterOpLock > 0; util.Config.MasterOpLock-- {
-				time.Sleep(1 * time.Second)
-
-				// non-blocking select to check for channel message indicating a resume api call has been made and we can break the loop
-				select {
-				case msg := <-pauseChan:
-					util.Config.MasterOpLock = msg
-					if msg <= 0 {
-						// either received an indefinite pause (<0) or a resume (=0), so loop with unlock final action is no longer needed
-						return
-					}
-				default:
-				}
-			}
-			logger.Info("Pause timeout reached; unpausing operations")
-			util.Config.MasterOpLock = 0
-		}()
+	if err != nil {
+		logger.Errorf("could not process command from topic %s, received error %v", commandTopic, err)
 	}
 }
 
-// helper function to resume garage operations
-// if there is currently a finite pause in progress, it will send
-// the new finite duration to the channel to be consumed by the currently
-// runnin goroutine with the updated value; else it will set the lock back to 0,
-// which is the disabled value (thereby resuming garage operations)
-func resumeOperations() {
-	logger.Info("Received request to resume operations, resuming...")
-	if util.Config.MasterOpLock > 0 {
-		// send signal to pause timeout loop it's no longer needed
-		// send as goroutine as we only read channel every 1 second, so this ensures fast api response while waiting for channel to be read by loop
-		go func() { pauseChan <- 0 }()
-	} else if util.Config.MasterOpLock < 0 {
-		util.Config.MasterOpLock = 0 // override indefinite pause
+// publishes the pauses in effect to the pause topic as a retained message, so it's available to new subscribers
+func publishPauses(client mqtt.Client, pauses []geo.PauseStatus) {
+	payload, err := json.Marshal(pauses)
+	if err != nil {
+		logger.Warnf("Unable to marshal pauses, received error: %v", err)
+		return
+	}
+	token := client.Publish(pauseTopic, 0, true, payload)
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		logger.Warnf("Unable to publish pauses to topic %s, received error: %v", pauseTopic, token.Error())
 	}
 }
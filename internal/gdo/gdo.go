@@ -9,6 +9,7 @@ import (
 	"github.com/brchri/tesla-geogdo/internal/gdo/http"
 	"github.com/brchri/tesla-geogdo/internal/gdo/mqtt"
 	"github.com/brchri/tesla-geogdo/internal/gdo/ratgdo"
+	"github.com/brchri/tesla-geogdo/internal/gdo/state"
 )
 
 type GDO interface {
@@ -16,6 +17,9 @@ type GDO interface {
 	SetGarageDoor(action string) (err error)
 	// process any required shutdown events, such as service disconnects
 	ProcessShutdown()
+	// returns the last known state, availability, and obstruction of the garage door as reported by the opener;
+	// values the opener doesn't report are unknown
+	Status() state.Status
 }
 
 func Initialize(config map[string]interface{}) (GDO, error) {
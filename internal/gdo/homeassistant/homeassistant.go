@@ -19,6 +19,7 @@ type HomeAssistant struct {
 		} `yaml:"connection"`
 		EntityId           string `yaml:"entity_id"`
 		EnableStatusChecks bool   `yaml:"enable_status_checks"`
+		StatusPollInterval int    `yaml:"status_poll_interval"` // optional, seconds between status checks to detect manual operations; requires enable_status_checks
 	} `yaml:"settings"`
 }
 
@@ -36,6 +37,7 @@ func Initialize(config map[string]interface{}) (httpGdo.HttpGdo, error) {
 	if err != nil {
 		return nil, err
 	}
+	h.StartStatusPolling()
 	return h, nil
 }
 
@@ -82,7 +84,8 @@ func NewHomeAssistantGdo(config map[string]interface{}) (httpGdo.HttpGdo, error)
 
 		if hassGdo.Settings.EnableStatusChecks {
 			httpSettings["status"] = map[string]interface{}{
-				"endpoint": "/api/states/" + hassGdo.Settings.EntityId,
+				"endpoint":      "/api/states/" + hassGdo.Settings.EntityId,
+				"poll_interval": hassGdo.Settings.StatusPollInterval,
 				"headers": []string{
 					"Authorization: Bearer " + hassGdo.Settings.Connection.ApiKey,
 					"Content-Type: application/json",
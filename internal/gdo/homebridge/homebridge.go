@@ -9,8 +9,10 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/brchri/tesla-geogdo/internal/gdo/state"
 	"github.com/brchri/tesla-geogdo/internal/util"
 	logger "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -20,6 +22,12 @@ type (
 	HomebridgeGdo interface {
 		SetGarageDoor(string) error
 		ProcessShutdown()
+		// returns the normalized door state as reported by the status characteristic
+		DoorState() *state.Door
+		// returns a snapshot of the normalized door state
+		Status() state.Status
+		// starts polling the status characteristic in the background if a poll interval is configured
+		StartStatusPolling()
 	}
 
 	homebridgeGdo struct {
@@ -32,8 +40,9 @@ type (
 				UseTls        bool   `yaml:"use_tls"`
 				SkipTlsVerify bool   `yaml:"skip_tls_verify"`
 			} `yaml:"connection"`
-			Timeout   int `yaml:"timeout"`
-			Accessory struct {
+			Timeout            int `yaml:"timeout"`
+			StatusPollInterval int `yaml:"status_poll_interval"` // optional, seconds between status checks to detect manual operations; requires a status characteristic
+			Accessory          struct {
 				UniqueId        string `yaml:"unique_id"`
 				Characteristics struct {
 					Status  string `yaml:"status"`
@@ -45,12 +54,17 @@ type (
 				} `yaml:"characteristics"`
 			} `yaml:"accessory"`
 		}
-		authToken string
+		authToken   string
+		Door        state.Door // normalized door state, used to detect manual operations
+		stopPolling chan struct{}
+
+		tokenLock sync.Mutex // guards authToken, which is also refreshed by status polling
 	}
 )
 
 const (
-	defaultPort = 8581
+	defaultPort               = 8581
+	obstructionCharacteristic = "ObstructionDetected"
 )
 
 func init() {
@@ -62,7 +76,12 @@ func init() {
 }
 
 func Initialize(config map[string]interface{}) (HomebridgeGdo, error) {
-	return NewHomebridgeGdo(config)
+	h, err := NewHomebridgeGdo(config)
+	if err != nil {
+		return nil, err
+	}
+	h.StartStatusPolling()
+	return h, nil
 }
 
 func NewHomebridgeGdo(config map[string]interface{}) (HomebridgeGdo, error) {
@@ -161,8 +180,9 @@ func (h *homebridgeGdo) SetGarageDoor(action string) error {
 	}
 	headers := map[string]string{
 		"Content-Type":  "application/json",
-		"Authorization": "Bearer " + h.authToken,
+		"Authorization": "Bearer " + h.token(),
 	}
+	h.Door.ExpectChange(time.Duration(h.Settings.Timeout) * time.Second)
 	_, err = h.ExecuteApiCall(endpoint, "PUT", string(body), headers)
 	if err != nil {
 		return fmt.Errorf("received error when executing api call to homebridge server: %v", err)
@@ -196,10 +216,11 @@ func (h *homebridgeGdo) getDoorStatus() (string, error) {
 	endpoint := "/api/accessories/" + h.Settings.Accessory.UniqueId
 	headers := map[string]string{
 		"Content-Type":  "application/json",
-		"Authorization": "Bearer " + h.authToken,
+		"Authorization": "Bearer " + h.token(),
 	}
 	rBody, err := h.ExecuteApiCall(endpoint, "GET", "", headers)
 	if err != nil {
+		h.Door.SetAvailability(state.Offline)
 		return "", err
 	}
 
@@ -212,16 +233,71 @@ func (h *homebridgeGdo) getDoorStatus() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	h.Door.SetAvailability(state.Online)
+	// homekit garage door openers may also report obstructions
+	if v, ok := rb.Values[obstructionCharacteristic]; ok {
+		obstructed := fmt.Sprintf("%v", v)
+		h.Door.SetObstructed(obstructed == "true" || obstructed == "1")
+	}
 	for k, v := range rb.Values {
 		if k == h.Settings.Accessory.Characteristics.Status {
 			logger.Debugf("received door status: %v", v)
-			return fmt.Sprintf("%v", v), nil
+			status := fmt.Sprintf("%v", v)
+			h.Door.Update(h.normalizeStatus(status))
+			return status, nil
 		}
 	}
 
 	return "", fmt.Errorf("could not get door status")
 }
 
+// maps the status characteristic value to a door state, using the configured open and close values
+// if they match, or the homekit current door state values otherwise
+func (h *homebridgeGdo) normalizeStatus(status string) state.DoorState {
+	switch status {
+	case fmt.Sprintf("%v", h.Settings.Accessory.Characteristics.Values.Open):
+		return state.Open
+	case fmt.Sprintf("%v", h.Settings.Accessory.Characteristics.Values.Close):
+		return state.Closed
+	}
+	return state.Normalize(status)
+}
+
+func (h *homebridgeGdo) StartStatusPolling() {
+	if h.Settings.Accessory.Characteristics.Status == "" || h.Settings.StatusPollInterval <= 0 || h.stopPolling != nil {
+		return
+	}
+	h.stopPolling = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Duration(h.Settings.StatusPollInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// the auth token may have expired, so log in again if the status can't be retrieved
+				if _, err := h.getDoorStatus(); err != nil {
+					if err = h.login(); err == nil {
+						_, err = h.getDoorStatus()
+					}
+					if err != nil {
+						logger.Debugf("Unable to poll door state, received err: %v", err)
+					}
+				}
+			case <-h.stopPolling:
+				return
+			}
+		}
+	}()
+}
+
+func (h *homebridgeGdo) DoorState() *state.Door {
+	return &h.Door
+}
+
+func (h *homebridgeGdo) Status() state.Status {
+	return h.Door.Status()
+}
+
 func (h *homebridgeGdo) login() error {
 	logger.Debug("logging into homebridge")
 	type loginBody struct {
@@ -262,11 +338,20 @@ func (h *homebridgeGdo) login() error {
 	if rb.AccessToken == "" {
 		return fmt.Errorf("unable to retrieve access token from Homebridge server")
 	}
+	h.tokenLock.Lock()
 	h.authToken = rb.AccessToken
+	h.tokenLock.Unlock()
 
 	return nil
 }
 
+// returns the auth token from the last login
+func (h *homebridgeGdo) token() string {
+	h.tokenLock.Lock()
+	defer h.tokenLock.Unlock()
+	return h.authToken
+}
+
 func (h *homebridgeGdo) ExecuteApiCall(endpoint string, method string, body string, headers map[string]string) (respBody string, err error) {
 	// build url api prefix
 	urlPrefix := "http"
@@ -314,5 +399,9 @@ func (h *homebridgeGdo) ExecuteApiCall(endpoint string, method string, body stri
 	return string(rBody), err
 }
 
-// stubbed function as there's no need to process shutdown for homebridgeGdo
-func (h *homebridgeGdo) ProcessShutdown() {}
+// stops status polling if it was started
+func (h *homebridgeGdo) ProcessShutdown() {
+	if h.stopPolling != nil {
+		close(h.stopPolling)
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/brchri/tesla-geogdo/internal/gdo/state"
 	"github.com/brchri/tesla-geogdo/internal/util"
 	logger "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -24,6 +26,12 @@ type (
 		// expected for status; the parsing function, if set, will be used to extract that
 		// simple status from more complex responses
 		SetParseStatusResponseFunc(ParseStatusResponseFunc)
+		// returns the normalized door state as reported by the status endpoint
+		DoorState() *state.Door
+		// returns a snapshot of the normalized door state
+		Status() state.Status
+		// starts polling the status endpoint in the background if a poll interval is configured
+		StartStatusPolling()
 	}
 
 	ParseStatusResponseFunc func(string) (string, error)
@@ -41,14 +49,19 @@ type (
 			Status struct {
 				Endpoint            string   `yaml:"endpoint,omitempty"`
 				Headers             []string `yaml:"headers,omitempty"`
+				PollInterval        int      `yaml:"poll_interval,omitempty"` // optional, seconds between status checks to detect manual operations; 0 only checks status when operating the door
 				ParseStatusResponse ParseStatusResponseFunc
 			} `yaml:"status,omitempty"`
 			Commands []Command `yaml:"commands"`
 		} `yaml:"settings"`
-		OpenerType   string `yaml:"type"` // name used by this module can be overridden by consuming modules, such as ratgdo, which is a wrapper for this package
-		State        string // state of the garage door
-		Availability string // if the garage door controller publishes an availability status (e.g. online), it will be stored here
-		Obstruction  string // if the garage door controller publishes obstruction information, it will be stored here
+		OpenerType   string     `yaml:"type"` // name used by this module can be overridden by consuming modules, such as ratgdo, which is a wrapper for this package
+		State        string     // state of the garage door
+		Availability string     // if the garage door controller publishes an availability status (e.g. online), it will be stored here
+		Obstruction  string     // if the garage door controller publishes obstruction information, it will be stored here
+		Door         state.Door // normalized door state, used to detect manual operations
+		stopPolling  chan struct{}
+
+		lock sync.Mutex // guards State and Availability, which are also updated by status polling
 	}
 
 	Command struct {
@@ -77,7 +90,12 @@ func init() {
 }
 
 func Initialize(config map[string]interface{}) (HttpGdo, error) {
-	return NewHttpGdo(config)
+	h, err := NewHttpGdo(config)
+	if err != nil {
+		return nil, err
+	}
+	h.StartStatusPolling()
+	return h, nil
 }
 
 func NewHttpGdo(config map[string]interface{}) (HttpGdo, error) {
@@ -164,16 +182,11 @@ func (h *httpGdo) SetGarageDoor(action string) error {
 
 	// validate required door state
 	if command.RequiredStartState != "" && h.Settings.Status.Endpoint != "" {
-		var err error
-		h.State, err = h.getDoorStatus()
-		if err == nil && h.Settings.Status.ParseStatusResponse != nil {
-			h.State, err = h.Settings.Status.ParseStatusResponse(h.State)
-		}
-		if err != nil {
+		if err := h.updateDoorState(); err != nil {
 			return fmt.Errorf("unable to get door state, received err: %v", err)
 		}
-		if h.State != "" && h.State != command.RequiredStartState {
-			logger.Warnf("Action and state mismatch: garage state is not valid for executing requested action; current state %s; requested action: %s", h.State, action)
+		if s := h.currentState(); s != "" && s != command.RequiredStartState {
+			logger.Warnf("Action and state mismatch: garage state is not valid for executing requested action; current state %s; requested action: %s", s, action)
 			return nil
 		}
 	}
@@ -210,6 +223,7 @@ func (h *httpGdo) SetGarageDoor(action string) error {
 	}
 
 	// execute request
+	h.Door.ExpectChange(time.Duration(command.Timeout) * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("unable to send command to http endpoint, received err: %v", err)
@@ -230,24 +244,96 @@ func (h *httpGdo) SetGarageDoor(action string) error {
 	// wait for timeout
 	start := time.Now()
 	for time.Since(start) < time.Duration(command.Timeout)*time.Second {
-		h.State, err = h.getDoorStatus()
-		if err == nil && h.Settings.Status.ParseStatusResponse != nil {
-			h.State, err = h.Settings.Status.ParseStatusResponse(h.State)
-		}
-		if err != nil {
+		if err = h.updateDoorState(); err != nil {
 			logger.Debugf("Unable to get door state, received err: %v", err)
 			logger.Debugf("Will keep trying until timeout expires")
-		} else if h.State == command.RequiredFinishState {
+		} else if s := h.currentState(); s == command.RequiredFinishState {
 			logger.Infof("Garage door state has been set successfully: %s", command.RequiredFinishState)
 			return nil
 		} else {
-			logger.Debugf("Current opener state: %s", h.State)
+			logger.Debugf("Current opener state: %s", s)
 		}
 		time.Sleep(1 * time.Second)
 	}
 
 	// if we've hit this point, then we've timed out waiting for the garage to reach the requiredFinishState
-	return fmt.Errorf("command sent to http endpoint, but timed out waiting for door to reach required_finish_state %s; current door state: %s", command.RequiredFinishState, h.State)
+	return fmt.Errorf("command sent to http endpoint, but timed out waiting for door to reach required_finish_state %s; current door state: %s", command.RequiredFinishState, h.currentState())
+}
+
+// returns the last status retrieved from the status endpoint
+func (h *httpGdo) currentState() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.State
+}
+
+// records the controller's availability
+func (h *httpGdo) setAvailability(availability state.Availability) {
+	h.lock.Lock()
+	h.Availability = string(availability)
+	h.lock.Unlock()
+	h.Door.SetAvailability(availability)
+}
+
+// gets the door status from the status endpoint, parsing it if required, and updates the door state
+// the controller is considered offline if the status can't be retrieved or reports itself unavailable
+func (h *httpGdo) updateDoorState() error {
+	status, err := h.getDoorStatus()
+	if err == nil && h.Settings.Status.ParseStatusResponse != nil {
+		status, err = h.Settings.Status.ParseStatusResponse(status)
+	}
+	if err != nil {
+		if h.Settings.Status.Endpoint != "" {
+			h.setAvailability(state.Offline)
+		}
+		return err
+	}
+	h.lock.Lock()
+	h.State = status
+	h.lock.Unlock()
+	if status == "" {
+		return nil
+	}
+	s := state.Normalize(status)
+	if s == state.Unknown && state.NormalizeAvailability(status) == state.Offline {
+		h.setAvailability(state.Offline)
+		return nil
+	}
+	h.setAvailability(state.Online)
+	h.Door.SetObstructed(s == state.Obstructed)
+	if s != state.Obstructed {
+		h.Door.Update(s)
+	}
+	return nil
+}
+
+func (h *httpGdo) StartStatusPolling() {
+	if h.Settings.Status.Endpoint == "" || h.Settings.Status.PollInterval <= 0 || h.stopPolling != nil {
+		return
+	}
+	h.stopPolling = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Duration(h.Settings.Status.PollInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := h.updateDoorState(); err != nil {
+					logger.Debugf("Unable to poll door state, received err: %v", err)
+				}
+			case <-h.stopPolling:
+				return
+			}
+		}
+	}()
+}
+
+func (h *httpGdo) DoorState() *state.Door {
+	return &h.Door
+}
+
+func (h *httpGdo) Status() state.Status {
+	return h.Door.Status()
 }
 
 func (h *httpGdo) getDoorStatus() (string, error) {
@@ -304,7 +390,9 @@ func addHeadersToReq(req *http.Request, headers []string) {
 	}
 }
 
-// stubbed function for rquired interface, no shutdown routines required for this package
+// stops status polling if it was started
 func (h *httpGdo) ProcessShutdown() {
-
+	if h.stopPolling != nil {
+		close(h.stopPolling)
+	}
 }
@@ -12,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/brchri/tesla-geogdo/internal/gdo/state"
 	"github.com/brchri/tesla-geogdo/internal/util"
 	"github.com/stretchr/testify/assert"
 )
@@ -181,6 +182,42 @@ func Test_getDoorStatus(t *testing.T) {
 	assert.Equal(t, "closed", state)
 }
 
+func Test_updateDoorState(t *testing.T) {
+	h, err := NewHttpGdo(sampleYaml)
+	assert.Equal(t, nil, err)
+	httpGdo, ok := h.(*httpGdo)
+	if !ok {
+		t.Error("returned type is not *httpGdo")
+		return
+	}
+
+	mockServer := httptest.NewServer(http.HandlerFunc(mockServerHandler))
+	defer mockServer.Close()
+	re := regexp.MustCompile(`http[s]?:\/\/(.+):(.*)`)
+	matches := re.FindStringSubmatch(mockServer.URL)
+	httpGdo.Settings.Connection.Host = matches[1]
+	serverPort, _ := strconv.ParseInt(matches[2], 10, 32)
+	httpGdo.Settings.Connection.Port = int(serverPort)
+
+	doorStateToReturn = "closed"
+	assert.Equal(t, nil, httpGdo.updateDoorState())
+	assert.Equal(t, state.Closed, httpGdo.DoorState().State())
+	assert.Equal(t, true, httpGdo.DoorState().LastManualOperation().IsZero())
+
+	// door opened without a command is a manual operation
+	doorStateToReturn = "open"
+	assert.Equal(t, nil, httpGdo.updateDoorState())
+	assert.Equal(t, state.Open, httpGdo.DoorState().State())
+	assert.WithinDuration(t, time.Now(), httpGdo.DoorState().LastManualOperation(), time.Second)
+	assert.Equal(t, state.Online, httpGdo.Status().Availability)
+
+	// unreachable status endpoint means the controller is offline
+	mockServer.Close()
+	assert.NotNil(t, httpGdo.updateDoorState())
+	assert.Equal(t, state.Offline, httpGdo.Status().Availability)
+	assert.Equal(t, state.Open, httpGdo.Status().State)
+}
+
 // check SetGarageDoor with no status checks
 func Test_SetGarageDoor_Open_NoStatus(t *testing.T) {
 	h, err := NewHttpGdo(sampleYaml)
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/brchri/tesla-geogdo/internal/gdo/state"
 	"github.com/brchri/tesla-geogdo/internal/util"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/google/uuid"
@@ -30,6 +31,10 @@ type (
 		SetGarageDoor(string) error
 		// process any required shutdown events, such as service disconnects
 		ProcessShutdown()
+		// DoorState returns the normalized door state as reported on the door status, availability, and obstruction topics
+		DoorState() *state.Door
+		// Status returns a snapshot of the normalized door state
+		Status() state.Status
 	}
 
 	// mqttGdo is the struct that implements the MqttGdo interface
@@ -57,6 +62,7 @@ type (
 		State        string      // state of the garage door
 		Availability string      // if the garage door controller publishes an availability status (e.g. online), it will be stored here
 		Obstruction  string      // if the garage door controller publishes obstruction information, it will be stored here
+		Door         state.Door  // normalized door state, used to detect manual operations
 	}
 
 	Command struct {
@@ -285,10 +291,13 @@ func (m *mqttGdo) processMqttMessage(client mqtt.Client, message mqtt.Message) {
 	switch strings.TrimPrefix(message.Topic(), m.Settings.Topics.Prefix+"/") {
 	case m.Settings.Topics.DoorStatus:
 		m.State = string(message.Payload())
+		m.Door.Update(state.Normalize(m.State))
 	case m.Settings.Topics.Availability:
 		m.Availability = string(message.Payload())
+		m.Door.SetAvailability(state.NormalizeAvailability(m.Availability))
 	case m.Settings.Topics.Obstruction:
 		m.Obstruction = string(message.Payload())
+		m.Door.SetObstructed(m.Obstruction == "obstructed")
 	default:
 		logger.Debugf("invalid message topic: %s", message.Topic())
 	}
@@ -324,6 +333,7 @@ func (m *mqttGdo) SetGarageDoor(action string) (err error) {
 	logger.Infof("setting garage door %s", action)
 	logger.Debugf("Reported MqttGdo availability: %s", m.Availability)
 
+	m.Door.ExpectChange(time.Duration(command.Timeout) * time.Second)
 	token := m.MqttClient.Publish(m.Settings.Topics.Prefix+"/"+command.TopicSuffix, 0, false, command.Payload)
 	token.Wait()
 
@@ -355,6 +365,14 @@ func (m *mqttGdo) SetGarageDoor(action string) (err error) {
 	return
 }
 
+func (m *mqttGdo) DoorState() *state.Door {
+	return &m.Door
+}
+
+func (m *mqttGdo) Status() state.Status {
+	return m.Door.Status()
+}
+
 func (m *mqttGdo) ProcessShutdown() {
 	m.MqttClient.Disconnect(250)
 }
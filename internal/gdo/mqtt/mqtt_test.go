@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/brchri/tesla-geogdo/internal/gdo/state"
 	"github.com/brchri/tesla-geogdo/internal/mocks"
 	"github.com/brchri/tesla-geogdo/internal/util"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -190,3 +191,47 @@ func Test_SetGarageDoor_NoStatus(t *testing.T) {
 
 	assert.Equal(t, nil, mqttGdo.SetGarageDoor("open"))
 }
+
+// minimal mqtt message for testing processMqttMessage
+type testMessage struct {
+	topic   string
+	payload string
+}
+
+func (m testMessage) Duplicate() bool   { return false }
+func (m testMessage) Qos() byte         { return 0 }
+func (m testMessage) Retained() bool    { return false }
+func (m testMessage) Topic() string     { return m.topic }
+func (m testMessage) MessageID() uint16 { return 0 }
+func (m testMessage) Payload() []byte   { return []byte(m.payload) }
+func (m testMessage) Ack()              {}
+
+func Test_processMqttMessage_DoorState(t *testing.T) {
+	m, err := NewMqttGdo(sampleYaml)
+	assert.Equal(t, nil, err)
+	mqttGdo, ok := m.(*mqttGdo)
+	if !ok {
+		t.Error("returned type is not *mqttGdo")
+		return
+	}
+
+	mqttGdo.processMqttMessage(nil, testMessage{"home/garage/Main/status/door", "closed"})
+	assert.Equal(t, "closed", mqttGdo.State)
+	assert.Equal(t, state.Closed, mqttGdo.DoorState().State())
+
+	// door opened without a command is a manual operation
+	mqttGdo.processMqttMessage(nil, testMessage{"home/garage/Main/status/door", "opening"})
+	assert.Equal(t, state.Opening, mqttGdo.DoorState().State())
+	assert.WithinDuration(t, time.Now(), mqttGdo.DoorState().LastManualOperation(), time.Second)
+
+	mqttGdo.processMqttMessage(nil, testMessage{"home/garage/Main/status/obstruction", "obstructed"})
+	assert.Equal(t, state.Obstructed, mqttGdo.DoorState().State())
+	mqttGdo.processMqttMessage(nil, testMessage{"home/garage/Main/status/obstruction", "clear"})
+	assert.Equal(t, state.Opening, mqttGdo.DoorState().State())
+
+	mqttGdo.processMqttMessage(nil, testMessage{"home/garage/Main/status/availability", "offline"})
+	status := mqttGdo.Status()
+	assert.Equal(t, state.Offline, status.Availability)
+	assert.Equal(t, false, status.Obstructed)
+	assert.WithinDuration(t, time.Now(), status.LastUpdated, time.Second)
+}
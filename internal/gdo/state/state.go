@@ -0,0 +1,213 @@
+package state
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brchri/tesla-geogdo/internal/util"
+	logger "github.com/sirupsen/logrus"
+)
+
+type (
+	// normalized state of a garage door, shared by all opener types
+	DoorState string
+
+	// normalized availability of a garage door controller, shared by all opener types
+	Availability string
+
+	// snapshot of everything known about a garage door from its opener
+	Status struct {
+		State               DoorState    `json:"state"`
+		Availability        Availability `json:"availability"`
+		Obstructed          bool         `json:"obstructed"`
+		LastUpdated         time.Time    `json:"last_updated"`          // time the state, availability, or obstruction was last reported; zero if never reported
+		LastManualOperation time.Time    `json:"last_manual_operation"` // time a manual operation was last detected; zero if none was detected
+	}
+
+	// tracks the state of a garage door as reported by its opener's status source, and detects
+	// operations that weren't initiated by this service (e.g. a wall button or remote)
+	Door struct {
+		state         DoorState
+		obstructed    bool
+		availability  Availability
+		updated       time.Time // time the state, availability, or obstruction was last reported
+		expectedUntil time.Time // changes before this time are attributed to a command sent by this service
+		lastManual    time.Time // time a manual operation was last detected
+		lock          sync.Mutex
+
+		obstructedByState bool // the obstruction was reported as the door state, rather than by a separate obstruction source
+	}
+)
+
+const (
+	Open       DoorState = "open"
+	Closed     DoorState = "closed"
+	Opening    DoorState = "opening"
+	Closing    DoorState = "closing"
+	Stopped    DoorState = "stopped"
+	Unknown    DoorState = "unknown"
+	Obstructed DoorState = "obstructed"
+
+	Online              Availability = "online"
+	Offline             Availability = "offline"
+	UnknownAvailability Availability = "unknown"
+)
+
+// additional time after a command's timeout to attribute state changes to the command,
+// as some openers report the final state shortly after the door stops moving
+const commandGracePeriod = 5 * time.Second
+
+func init() {
+	logger.SetFormatter(&util.CustomFormatter{})
+	logger.SetOutput(os.Stdout)
+	if val, ok := os.LookupEnv("DEBUG"); ok && strings.ToLower(val) == "true" {
+		logger.SetLevel(logger.DebugLevel)
+	}
+}
+
+// maps a raw state reported by an opener to a door state; understands the states reported by ratgdo and
+// home assistant covers, as well as the numeric homekit current door state (0 = open, 1 = closed, 2 = opening,
+// 3 = closing, 4 = stopped); anything else is unknown
+func Normalize(raw string) DoorState {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "open", "0":
+		return Open
+	case "closed", "1":
+		return Closed
+	case "opening", "2":
+		return Opening
+	case "closing", "3":
+		return Closing
+	case "stopped", "4":
+		return Stopped
+	case "obstructed":
+		return Obstructed
+	default:
+		return Unknown
+	}
+}
+
+// maps a raw availability reported by an opener to an availability; understands the values reported by ratgdo
+// and home assistant entities; anything else is unknown
+func NormalizeAvailability(raw string) Availability {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "online", "available", "true":
+		return Online
+	case "offline", "unavailable", "false":
+		return Offline
+	default:
+		return UnknownAvailability
+	}
+}
+
+// returns the current state of the door; an obstruction takes precedence over the reported state
+func (d *Door) State() DoorState {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.obstructed {
+		return Obstructed
+	}
+	if d.state == "" {
+		return Unknown
+	}
+	return d.state
+}
+
+// returns the availability of the door's controller
+func (d *Door) Availability() Availability {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.availability == "" {
+		return UnknownAvailability
+	}
+	return d.availability
+}
+
+// indicates whether an obstruction is currently reported for the door
+func (d *Door) Obstructed() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.obstructed
+}
+
+// returns the time the door's state, availability, or obstruction was last reported
+func (d *Door) LastUpdated() time.Time {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.updated
+}
+
+// returns the time a manual operation was last detected, or the zero time if none was detected
+func (d *Door) LastManualOperation() time.Time {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.lastManual
+}
+
+// indicates the door is about to be operated by this service, so state changes within the timeout
+// (plus a short grace period) should not be considered manual operations
+func (d *Door) ExpectChange(timeout time.Duration) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.expectedUntil = time.Now().Add(timeout + commandGracePeriod)
+}
+
+// updates the door's state, detecting manual operations; a change between known states
+// that wasn't expected is considered a manual operation; an obstruction reported as the state
+// is cleared once the opener reports any other known state
+func (d *Door) Update(s DoorState) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := time.Now()
+	d.updated = now
+	if s == Obstructed {
+		d.obstructed = true
+		d.obstructedByState = true
+		return
+	}
+	if d.obstructedByState && s != Unknown {
+		d.obstructed = false
+		d.obstructedByState = false
+	}
+	prev := d.state
+	d.state = s
+	if prev == s || prev == "" || prev == Unknown || s == Unknown {
+		return
+	}
+	if now.Before(d.expectedUntil) {
+		logger.Debugf("Garage door state changed from %s to %s following a command", prev, s)
+		return
+	}
+	logger.Infof("Garage door state changed from %s to %s without a command, treating it as a manual operation", prev, s)
+	d.lastManual = now
+}
+
+// updates whether an obstruction is reported for the door
+func (d *Door) SetObstructed(obstructed bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.updated = time.Now()
+	d.obstructed = obstructed
+	d.obstructedByState = false
+}
+
+// updates the availability of the door's controller
+func (d *Door) SetAvailability(availability Availability) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.updated = time.Now()
+	d.availability = availability
+}
+
+// returns a snapshot of the door's state
+func (d *Door) Status() Status {
+	return Status{
+		State:               d.State(),
+		Availability:        d.Availability(),
+		Obstructed:          d.Obstructed(),
+		LastUpdated:         d.LastUpdated(),
+		LastManualOperation: d.LastManualOperation(),
+	}
+}
@@ -0,0 +1,88 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Normalize(t *testing.T) {
+	assert.Equal(t, Open, Normalize("open"))
+	assert.Equal(t, Closed, Normalize("Closed\n"))
+	assert.Equal(t, Opening, Normalize("2"))
+	assert.Equal(t, Stopped, Normalize("4"))
+	assert.Equal(t, Unknown, Normalize("unavailable"))
+	assert.Equal(t, Unknown, Normalize(""))
+}
+
+func Test_Door(t *testing.T) {
+	var d Door
+	assert.Equal(t, Unknown, d.State())
+
+	// initial state is not a manual operation
+	d.Update(Closed)
+	assert.Equal(t, Closed, d.State())
+	assert.Equal(t, true, d.LastManualOperation().IsZero())
+	assert.WithinDuration(t, time.Now(), d.LastUpdated(), time.Second)
+
+	// expected changes are not manual operations
+	d.ExpectChange(time.Second)
+	d.Update(Opening)
+	d.Update(Open)
+	assert.Equal(t, true, d.LastManualOperation().IsZero())
+
+	// unexpected changes are manual operations
+	d.expectedUntil = time.Time{}
+	d.Update(Closing)
+	assert.WithinDuration(t, time.Now(), d.LastManualOperation(), time.Second)
+
+	// going offline and back isn't a manual operation
+	d.lastManual = time.Time{}
+	d.Update(Unknown)
+	d.Update(Closed)
+	assert.Equal(t, true, d.LastManualOperation().IsZero())
+
+	// obstruction takes precedence until cleared
+	d.Update(Obstructed)
+	assert.Equal(t, Obstructed, d.State())
+	d.SetObstructed(false)
+	assert.Equal(t, Closed, d.State())
+
+	// an obstruction reported as the state is cleared by the next known state
+	d.Update(Obstructed)
+	d.Update(Unknown)
+	assert.Equal(t, Obstructed, d.State())
+	d.Update(Closed)
+	assert.Equal(t, Closed, d.State())
+	assert.Equal(t, false, d.Obstructed())
+
+	// an obstruction reported separately is only cleared separately
+	d.SetObstructed(true)
+	d.Update(Closed)
+	assert.Equal(t, Obstructed, d.State())
+	d.SetObstructed(false)
+	assert.Equal(t, Closed, d.State())
+}
+
+func Test_NormalizeAvailability(t *testing.T) {
+	assert.Equal(t, Online, NormalizeAvailability("online"))
+	assert.Equal(t, Offline, NormalizeAvailability("Offline"))
+	assert.Equal(t, Offline, NormalizeAvailability("unavailable"))
+	assert.Equal(t, UnknownAvailability, NormalizeAvailability("something"))
+}
+
+func Test_Door_Status(t *testing.T) {
+	var d Door
+	assert.Equal(t, Status{State: Unknown, Availability: UnknownAvailability}, d.Status())
+
+	d.Update(Open)
+	d.SetAvailability(Online)
+	d.SetObstructed(true)
+	status := d.Status()
+	assert.Equal(t, Obstructed, status.State)
+	assert.Equal(t, Online, status.Availability)
+	assert.Equal(t, true, status.Obstructed)
+	assert.WithinDuration(t, time.Now(), status.LastUpdated, time.Second)
+	assert.Equal(t, true, status.LastManualOperation.IsZero())
+}
@@ -0,0 +1,485 @@
+package geo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+type (
+	// defines an ics calendar file whose events suppress automatic garage door actions for their duration,
+	// e.g. for vacations, house-sitters, or contractor visits
+	CalendarSettings struct {
+		File   string              `yaml:"file"`   // path to the ics file; the file is re-read when it changes
+		Events []CalendarEventRule `yaml:"events"` // events that suppress actions, matched by summary
+	}
+
+	// matches calendar events by summary and defines which actions they suppress
+	CalendarEventRule struct {
+		Summary string   `yaml:"summary"` // case-insensitive text the event summary must contain, e.g. vacation
+		Actions []string `yaml:"actions"` // optional, actions to suppress during the event; defaults to both open and close (pausing the garage door)
+	}
+
+	calendarEvent struct {
+		Summary    string
+		Start      time.Time
+		End        time.Time
+		Recurrence *recurrence // nil if the event doesn't recur
+		Excluded   []time.Time // starts of occurrences removed from the recurrence
+	}
+
+	// recurrence rule of a calendar event, see https://datatracker.ietf.org/doc/html/rfc5545#section-3.3.10
+	// only daily and weekly rules are supported
+	recurrence struct {
+		freq      string                // DAILY or WEEKLY
+		interval  int                   // days or weeks between occurrences
+		count     int                   // total number of occurrences; 0 if unlimited
+		until     time.Time             // latest start of an occurrence; zero if unlimited
+		byDay     map[time.Weekday]bool // days the event occurs on; defaults to every day for daily rules, or the day of the start for weekly rules
+		weekStart time.Weekday
+	}
+
+	// a calendar event currently suppressing actions for a garage door
+	CalendarStatus struct {
+		Event   string    `json:"event"`   // summary of the calendar event
+		Actions []string  `json:"actions"` // actions suppressed by the event
+		Until   time.Time `json:"until"`   // end of the event, or of its current occurrence if it recurs
+	}
+
+	// parsed events of an ics file, along with the file details used to detect changes
+	calendarFile struct {
+		modTime time.Time
+		size    int64
+		events  []calendarEvent
+	}
+)
+
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+var (
+	calendarCache = map[string]*calendarFile{} // parsed calendar files, keyed by path and timezone
+	calendarLock  sync.Mutex
+)
+
+// validates the calendar settings and loads the calendar file
+func (c *CalendarSettings) parse(loc *time.Location) error {
+	if c.File == "" {
+		return nil
+	}
+	if len(c.Events) == 0 {
+		return fmt.Errorf("calendar file %s is defined, but no events are defined to match", c.File)
+	}
+	for i, e := range c.Events {
+		if e.Summary == "" {
+			return fmt.Errorf("calendar event %d must define a summary", i)
+		}
+		if len(e.Actions) == 0 {
+			c.Events[i].Actions = []string{ActionOpen, ActionClose}
+		}
+		for _, a := range c.Events[i].Actions {
+			if a != ActionOpen && a != ActionClose {
+				return fmt.Errorf("calendar event action must be %s or %s, found '%s'", ActionOpen, ActionClose, a)
+			}
+		}
+	}
+	_, err := loadCalendar(c.File, loc)
+	return err
+}
+
+// indicates whether the action may be executed at the given time based on the calendar's events, and if not, the reason why
+func (c *CalendarSettings) allows(action string, t time.Time, loc *time.Location) (bool, string) {
+	for _, s := range c.suppressions(t, loc) {
+		if containsString(s.Actions, action) {
+			return false, fmt.Sprintf("calendar event '%s' suppresses %s actions until %s", s.Event, action, s.Until.In(t.Location()).Format("Mon Jan 2 15:04 MST"))
+		}
+	}
+	return true, ""
+}
+
+// returns the calendar events in progress at the given time that match a rule, along with the actions they suppress
+func (c *CalendarSettings) suppressions(t time.Time, loc *time.Location) []CalendarStatus {
+	if c.File == "" {
+		return nil
+	}
+	events, err := loadCalendar(c.File, loc)
+	if err != nil {
+		logger.Warnf("Unable to reload calendar file, using previously loaded events: %v", err)
+	}
+	var suppressions []CalendarStatus
+	for _, e := range events {
+		end, ok := e.occurrenceAt(t)
+		if !ok {
+			continue
+		}
+		s := CalendarStatus{Event: e.Summary, Until: end}
+		for _, rule := range c.Events {
+			if !strings.Contains(strings.ToLower(e.Summary), strings.ToLower(rule.Summary)) {
+				continue
+			}
+			for _, a := range rule.Actions {
+				if !containsString(s.Actions, a) {
+					s.Actions = append(s.Actions, a)
+				}
+			}
+		}
+		if len(s.Actions) > 0 {
+			suppressions = append(suppressions, s)
+		}
+	}
+	return suppressions
+}
+
+// returns the end of the event's occurrence in progress at the given time, if any
+func (e calendarEvent) occurrenceAt(t time.Time) (time.Time, bool) {
+	if e.Recurrence == nil {
+		return e.End, !t.Before(e.Start) && t.Before(e.End)
+	}
+	duration := e.End.Sub(e.Start)
+	var end time.Time
+	var found bool
+	e.Recurrence.each(e.Start, t, func(start time.Time) {
+		// later overlapping occurrences end later, so the last one in progress is kept
+		if t.Before(start.Add(duration)) && !e.isExcluded(start) {
+			end, found = start.Add(duration), true
+		}
+	})
+	return end, found
+}
+
+// indicates whether the occurrence starting at the given time was removed from the event's recurrence
+func (e calendarEvent) isExcluded(start time.Time) bool {
+	for _, x := range e.Excluded {
+		if x.Equal(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// parses an ics recurrence rule, e.g. FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20240301T000000Z
+func parseRecurrence(value string, start time.Time, loc *time.Location) (*recurrence, error) {
+	r := &recurrence{interval: 1, byDay: map[time.Weekday]bool{}, weekStart: time.Monday}
+	for _, part := range strings.Split(value, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		var err error
+		switch strings.ToUpper(k) {
+		case "FREQ":
+			r.freq = strings.ToUpper(v)
+			if r.freq != "DAILY" && r.freq != "WEEKLY" {
+				return nil, fmt.Errorf("unsupported frequency %s, only DAILY and WEEKLY are supported", v)
+			}
+		case "INTERVAL":
+			if r.interval, err = strconv.Atoi(v); err != nil || r.interval < 1 {
+				return nil, fmt.Errorf("invalid interval %s", v)
+			}
+		case "COUNT":
+			if r.count, err = strconv.Atoi(v); err != nil || r.count < 1 {
+				return nil, fmt.Errorf("invalid count %s", v)
+			}
+		case "UNTIL":
+			var date bool
+			if r.until, date, err = parseICSTime(v, map[string]string{}, loc); err != nil {
+				return nil, fmt.Errorf("invalid until %s", v)
+			}
+			if date {
+				// occurrences may start any time on the last date
+				r.until = r.until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+		case "BYDAY":
+			for _, d := range strings.Split(v, ",") {
+				wd, ok := icsWeekdays[strings.ToUpper(d)]
+				if !ok {
+					return nil, fmt.Errorf("unsupported day %s", d)
+				}
+				r.byDay[wd] = true
+			}
+		case "WKST":
+			var ok bool
+			if r.weekStart, ok = icsWeekdays[strings.ToUpper(v)]; !ok {
+				return nil, fmt.Errorf("invalid week start %s", v)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported rule part %s", k)
+		}
+	}
+	if r.freq == "" {
+		return nil, fmt.Errorf("missing frequency")
+	}
+	if r.freq == "WEEKLY" && len(r.byDay) == 0 {
+		r.byDay[start.Weekday()] = true
+	}
+	return r, nil
+}
+
+// calls fn with the start of each occurrence up to and including the last time, beginning with the event's start;
+// occurrences keep the start's time of day across daylight saving time changes
+func (r *recurrence) each(start, last time.Time, fn func(time.Time)) {
+	if !r.until.IsZero() && r.until.Before(last) {
+		last = r.until
+	}
+	n := 0
+	// returns false once the recurrence has ended
+	emit := func(t time.Time) bool {
+		if t.After(last) || (r.count > 0 && n >= r.count) {
+			return false
+		}
+		n++
+		fn(t)
+		return true
+	}
+	if !emit(start) {
+		return
+	}
+	if r.freq == "DAILY" {
+		for i := 1; ; i++ {
+			t := start.AddDate(0, 0, i*r.interval)
+			if t.After(last) {
+				return
+			}
+			if len(r.byDay) > 0 && !r.byDay[t.Weekday()] {
+				continue
+			}
+			if !emit(t) {
+				return
+			}
+		}
+	}
+	// weekly occurrences are on each of the days of every interval'th week, starting with the week of the start
+	week := start.AddDate(0, 0, -int((start.Weekday()-r.weekStart+7)%7))
+	for i := 0; ; i++ {
+		for d := 0; d < 7; d++ {
+			t := week.AddDate(0, 0, i*7*r.interval+d)
+			if t.After(last) {
+				return
+			}
+			if !t.After(start) || !r.byDay[t.Weekday()] {
+				continue
+			}
+			if !emit(t) {
+				return
+			}
+		}
+	}
+}
+
+// returns the events of the calendar file, reading and parsing it only if it changed since it was last loaded
+// if the file can't be read or parsed, the previously loaded events are returned along with the error
+func loadCalendar(path string, loc *time.Location) ([]calendarEvent, error) {
+	calendarLock.Lock()
+	defer calendarLock.Unlock()
+
+	key := path + "|" + loc.String()
+	cached := calendarCache[key]
+	var cachedEvents []calendarEvent
+	if cached != nil {
+		cachedEvents = cached.events
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return cachedEvents, fmt.Errorf("could not read calendar file %s, received error: %v", path, err)
+	}
+	if cached != nil && info.ModTime().Equal(cached.modTime) && info.Size() == cached.size {
+		return cached.events, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cachedEvents, fmt.Errorf("could not read calendar file %s, received error: %v", path, err)
+	}
+	events, err := parseICS(data, loc)
+	if err != nil {
+		return cachedEvents, fmt.Errorf("could not parse calendar file %s, received error: %v", path, err)
+	}
+	if cached != nil {
+		logger.Infof("Calendar file %s changed, loaded %d events", path, len(events))
+	} else {
+		logger.Debugf("Loaded %d events from calendar file %s", len(events), path)
+	}
+	calendarCache[key] = &calendarFile{modTime: info.ModTime(), size: info.Size(), events: events}
+	return events, nil
+}
+
+// parses the events of an ics calendar, see https://datatracker.ietf.org/doc/html/rfc5545
+// times without a timezone are in the given location; recurring events with unsupported rules are only considered
+// for their first occurrence
+func parseICS(data []byte, loc *time.Location) ([]calendarEvent, error) {
+	// unfold lines; long lines are continued on the next line starting with a space or tab
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var events []calendarEvent
+	var event *calendarEvent
+	var duration time.Duration
+	var allDay bool
+	var rule string
+	for _, line := range lines {
+		name, params, value := parseICSLine(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event, duration, allDay, rule = &calendarEvent{}, 0, false, ""
+		case event == nil:
+			continue
+		case name == "END" && value == "VEVENT":
+			if event.Start.IsZero() {
+				return nil, fmt.Errorf("event '%s' has no start time", event.Summary)
+			}
+			if event.End.IsZero() {
+				switch {
+				case duration > 0:
+					event.End = event.Start.Add(duration)
+				case allDay:
+					event.End = event.Start.AddDate(0, 0, 1)
+				default:
+					event.End = event.Start
+				}
+			}
+			if rule != "" {
+				r, err := parseRecurrence(rule, event.Start, loc)
+				if err != nil {
+					logger.Warnf("Calendar event '%s' has an unsupported recurrence rule %s (%v); only its first occurrence will suppress actions", event.Summary, rule, err)
+				}
+				event.Recurrence = r
+			}
+			events = append(events, *event)
+			event = nil
+		case name == "SUMMARY":
+			event.Summary = unescapeICSText(value)
+		case name == "DTSTART", name == "DTEND":
+			t, date, err := parseICSTime(value, params, loc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s for event '%s': %v", name, event.Summary, err)
+			}
+			if name == "DTSTART" {
+				event.Start, allDay = t, date
+			} else {
+				event.End = t
+			}
+		case name == "DURATION":
+			var err error
+			if duration, err = parseICSDuration(value); err != nil {
+				return nil, fmt.Errorf("invalid DURATION for event '%s': %v", event.Summary, err)
+			}
+		case name == "RRULE":
+			rule = value
+		case name == "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				t, _, err := parseICSTime(v, params, loc)
+				if err != nil {
+					return nil, fmt.Errorf("invalid EXDATE for event '%s': %v", event.Summary, err)
+				}
+				event.Excluded = append(event.Excluded, t)
+			}
+		}
+	}
+	return events, nil
+}
+
+// splits an ics content line into its name, parameters, and value, e.g. DTSTART;TZID=America/New_York:20240101T080000
+func parseICSLine(line string) (name string, params map[string]string, value string) {
+	nameAndParams, value, _ := strings.Cut(line, ":")
+	parts := strings.Split(nameAndParams, ";")
+	params = map[string]string{}
+	for _, p := range parts[1:] {
+		k, v, _ := strings.Cut(p, "=")
+		params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+	}
+	return strings.ToUpper(parts[0]), params, value
+}
+
+// parses an ics date or date-time value, returning whether it was a date (all-day) value
+func parseICSTime(value string, params map[string]string, loc *time.Location) (time.Time, bool, error) {
+	if tzid, ok := params["TZID"]; ok {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		} else {
+			logger.Debugf("Unknown calendar timezone %s, using %s", tzid, loc)
+		}
+	}
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// parses an ics duration, e.g. P1D, PT1H30M, or P2W
+func parseICSDuration(value string) (time.Duration, error) {
+	s := strings.TrimPrefix(strings.TrimPrefix(value, "+"), "P")
+	if strings.HasPrefix(value, "-") || s == value {
+		return 0, fmt.Errorf("unsupported duration %s", value)
+	}
+	var d time.Duration
+	var n int
+	inTime := false
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			n = n*10 + int(c-'0')
+			continue
+		case c == 'T':
+			inTime = true
+			continue
+		case c == 'W':
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case c == 'D':
+			d += time.Duration(n) * 24 * time.Hour
+		case c == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case c == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		case c == 'S' && inTime:
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("unsupported duration %s", value)
+		}
+		n = 0
+	}
+	return d, nil
+}
+
+// unescapes ics text values
+func unescapeICSText(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -5,15 +5,18 @@ import (
 	"math"
 	"time"
 
+	logger "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 )
 
 type (
 	// defines a center point and two radii (distances) to define open and close geofences
 	CircularGeofence struct {
-		Center        Point   `yaml:"center"`
-		CloseDistance float64 `yaml:"close_distance,omitempty"` // defines a radius from the center point; when vehicle moves from < distance to > distance, garage will close
-		OpenDistance  float64 `yaml:"open_distance,omitempty"`  // defines a radius from the center point; when vehicle moves from > distance to < distance, garage will open
+		Center        Point        `yaml:"center"`
+		CloseDistance float64      `yaml:"close_distance,omitempty"` // defines a radius from the center point; when vehicle moves from < distance to > distance, garage will close
+		OpenDistance  float64      `yaml:"open_distance,omitempty"`  // defines a radius from the center point; when vehicle moves from > distance to < distance, garage will open
+		OpenHeading   HeadingRange `yaml:"open_heading,omitempty"`   // optional, only open if the tracker's heading is within this range when entering the open geofence
+		OpenETA       int          `yaml:"open_eta,omitempty"`       // optional, seconds; open when an approaching tracker is estimated to reach the open geofence within this time based on its speed
 	}
 )
 
@@ -35,6 +38,10 @@ func toRadians(degrees float64) float64 {
 }
 
 // gets action based on if there was a relevant distance change
+func (c *CircularGeofence) isSeeded(tracker *Tracker) bool {
+	return tracker.CurrentLocation.IsPointDefined()
+}
+
 func (c *CircularGeofence) getEventChangeAction(tracker *Tracker) (action string) {
 	if !tracker.CurrentLocation.IsPointDefined() {
 		return // need valid lat and lng to check fence
@@ -58,15 +65,41 @@ func (c *CircularGeofence) getEventChangeAction(tracker *Tracker) (action string
 	if c.OpenDistance > 0 { // is valid open distance defined
 		if prevDistance >= c.OpenDistance &&
 			tracker.CurDistance < c.OpenDistance { // tracker was outside of open geofence, but is now within it (tracker entered geofence)
-			action = ActionOpen
+			openedByETA := tracker.OpenedByETA
+			tracker.WithinOpenETA, tracker.OpenedByETA = false, false
+			if openedByETA {
+				logger.Debugf("Tracker %v entered the open geofence, but the garage was already opened by its estimated arrival", tracker.ID)
+			} else if c.OpenHeading.allowsOpen(tracker) {
+				action = ActionOpen
+			}
 		} else if prevDistance < c.OpenDistance &&
 			tracker.CurDistance >= c.OpenDistance { // tracker just left open geofence
 			tracker.LastLeftOpenGeo = time.Now()
 		}
+		// if still outside the open geofence, check if the tracker will arrive within the open eta
+		if tracker.CurDistance >= c.OpenDistance &&
+			tracker.enteredOpenETA(c.OpenETA, tracker.CurDistance-c.OpenDistance, tracker.CurDistance < prevDistance) &&
+			c.OpenHeading.allowsOpen(tracker) {
+			action = ActionOpen
+		}
 	}
 	return
 }
 
+func (c *CircularGeofence) isOnActionSide(tracker *Tracker, action string) bool {
+	if action == ActionOpen {
+		return c.OpenDistance > 0 && tracker.CurDistance < c.OpenDistance
+	}
+	return c.CloseDistance > 0 && tracker.CurDistance > c.CloseDistance
+}
+
+func (c *CircularGeofence) isHome(tracker *Tracker) bool {
+	if c.CloseDistance > 0 {
+		return tracker.CurDistance <= c.CloseDistance
+	}
+	return c.OpenDistance > 0 && tracker.CurDistance < c.OpenDistance
+}
+
 func (c *CircularGeofence) parseSettings(config map[string]interface{}) error {
 	yamlData, err := yaml.Marshal(config)
 	var settings CircularGeofence
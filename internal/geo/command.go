@@ -0,0 +1,127 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/brchri/tesla-geogdo/internal/history"
+	"github.com/google/uuid"
+	logger "github.com/sirupsen/logrus"
+)
+
+type (
+	// an action requested for a garage door through the api rather than by a tracker
+	Command struct {
+		ID        string    `json:"id"`
+		Door      string    `json:"door"`
+		Action    string    `json:"action"`
+		Status    string    `json:"status"`          // one of the command status constants
+		Error     string    `json:"error,omitempty"` // error returned by the opener after all retries, if any
+		Requested time.Time `json:"requested"`
+		Completed time.Time `json:"completed,omitzero"`
+		done      chan struct{}
+	}
+)
+
+const (
+	CommandPending   = "pending"   // garage door is being operated
+	CommandSucceeded = "succeeded" // opener operated the garage door
+	CommandFailed    = "failed"    // opener returned an error after all retries
+)
+
+// number of commands retained so their status can be queried
+const maxCommands = 100
+
+var (
+	commands     []*Command // most recent commands, oldest first
+	commandsLock sync.Mutex // guards commands and the fields of each command
+)
+
+// operates the garage door through the same path as tracker actions, including the opener's retries and the
+// cooldown, unless garage operations are paused or the garage door is locked; geofence conditions, occupancy, and
+// flapping only apply to trackers, so aren't checked
+// returns an error if the command was rejected, otherwise the pending command, which is updated once the opener returns
+func (g *GarageDoor) SendCommand(action string) (*Command, error) {
+	if action != ActionOpen && action != ActionClose {
+		return nil, fmt.Errorf("action must be `%s` or `%s`, found '%s'", ActionOpen, ActionClose, action)
+	}
+	logger.Infof("Received command to %s garage door %s", action, g)
+	recordDoorEvent(g, history.Event{Type: history.TypeCommand, Action: action})
+
+	reject := func(reason string) (*Command, error) {
+		logger.Warnf("Will not execute command to %s garage door %s: %s", action, g, reason)
+		recordDoorEvent(g, history.Event{Type: history.TypeSuppressed, Action: action, Reason: reason})
+		return nil, errors.New(reason)
+	}
+	if p := g.pause(); p != nil {
+		return reject(fmt.Sprintf("garage operations are paused for %s", p.PauseScope))
+	}
+	if !g.tryLock() {
+		return reject("garage door is locked due to either cooldown or current activity")
+	}
+
+	c := &Command{
+		ID:        uuid.New().String(),
+		Door:      g.ID,
+		Action:    action,
+		Status:    CommandPending,
+		Requested: time.Now(),
+		done:      make(chan struct{}),
+	}
+	commandsLock.Lock()
+	commands = append(commands, c)
+	if len(commands) > maxCommands {
+		commands = append([]*Command(nil), commands[len(commands)-maxCommands:]...)
+	}
+	commandsLock.Unlock()
+
+	g.operate(action, nil, func(err error) {
+		commandsLock.Lock()
+		defer commandsLock.Unlock()
+		c.Completed = time.Now()
+		if err != nil {
+			c.Status = CommandFailed
+			c.Error = err.Error()
+		} else {
+			c.Status = CommandSucceeded
+		}
+		close(c.done)
+	})
+	return c, nil
+}
+
+// returns a channel that's closed once the opener has returned for the command
+func (c *Command) Done() <-chan struct{} {
+	return c.done
+}
+
+// returns a copy of the command's current status
+func (c *Command) Snapshot() Command {
+	commandsLock.Lock()
+	defer commandsLock.Unlock()
+	return *c
+}
+
+// returns the status of a retained command by its id
+func FindCommand(id string) (Command, bool) {
+	commandsLock.Lock()
+	defer commandsLock.Unlock()
+	for _, c := range commands {
+		if c.ID == id {
+			return *c, true
+		}
+	}
+	return Command{}, false
+}
+
+// returns the garage door matching the id, or nil if there's none
+func FindGarageDoor(id string) *GarageDoor {
+	for _, g := range GarageDoors {
+		if g.ID == id {
+			return g
+		}
+	}
+	return nil
+}
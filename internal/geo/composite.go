@@ -0,0 +1,150 @@
+package geo
+
+import (
+	"fmt"
+	"strings"
+
+	logger "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// combines multiple geofences of different types, e.g. to cross-check a polygon geofence against teslamate's own geofence
+	// with the `and` operator, an action is triggered when any child geofence triggers it and the tracker is on the action side
+	// of all other child geofences; with the `or` operator, an action is triggered when any child geofence triggers it
+	CompositeGeofence struct {
+		Operator        string                   `yaml:"operator,omitempty"` // `and` or `or`, defaults to `and`
+		GeofenceConfigs []map[string]interface{} `yaml:"geofences"`          // child geofence configs, each with a type and settings as for a garage door
+		Geofences       []GeofenceInterface      `yaml:"-"`                  // parsed child geofences
+	}
+)
+
+const (
+	OperatorAnd = "and"
+	OperatorOr  = "or"
+)
+
+// gets action based on the actions and states of the child geofences
+func (c *CompositeGeofence) getEventChangeAction(tracker *Tracker) (action string) {
+	// evaluate every child geofence so each updates the tracker's state for its type, even if an action is already found
+	actions := make([]string, len(c.Geofences))
+	for i, g := range c.Geofences {
+		actions[i] = g.getEventChangeAction(tracker)
+	}
+
+	for i, a := range actions {
+		if a == "" || a == action {
+			continue
+		}
+		if c.Operator == OperatorAnd && !c.othersOnActionSide(tracker, i, a) {
+			logger.Debugf("Geofence %d triggered action %s for tracker %v, but not all other geofences agree; will not %s", i, a, tracker.ID, a)
+			continue
+		}
+		if action != "" {
+			logger.Infof("Geofences triggered conflicting actions %s and %s for tracker %v; will not operate the garage", action, a, tracker.ID)
+			return ""
+		}
+		action = a
+	}
+	return
+}
+
+// indicates whether the tracker is on the action side of all child geofences other than the one at index skip
+func (c *CompositeGeofence) othersOnActionSide(tracker *Tracker, skip int, action string) bool {
+	for i, g := range c.Geofences {
+		if i != skip && !g.isOnActionSide(tracker, action) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *CompositeGeofence) isOnActionSide(tracker *Tracker, action string) bool {
+	return c.combine(func(g GeofenceInterface) bool { return g.isOnActionSide(tracker, action) })
+}
+
+func (c *CompositeGeofence) isHome(tracker *Tracker) bool {
+	return c.combine(func(g GeofenceInterface) bool { return g.isHome(tracker) })
+}
+
+// a composite geofence is only seeded once each child geofence has been, e.g. by both a location and a state
+func (c *CompositeGeofence) isSeeded(tracker *Tracker) bool {
+	for _, g := range c.Geofences {
+		if !g.isSeeded(tracker) {
+			return false
+		}
+	}
+	return true
+}
+
+// applies the check to the child geofences, requiring all of them to pass with the `and` operator, or any of them with `or`
+func (c *CompositeGeofence) combine(check func(GeofenceInterface) bool) bool {
+	for _, g := range c.Geofences {
+		passed := check(g)
+		if c.Operator == OperatorOr && passed {
+			return true
+		}
+		if c.Operator == OperatorAnd && !passed {
+			return false
+		}
+	}
+	return c.Operator == OperatorAnd
+}
+
+func (c *CompositeGeofence) parseSettings(config map[string]interface{}) error {
+	yamlData, err := yaml.Marshal(config)
+	var settings CompositeGeofence
+	if err != nil {
+		return fmt.Errorf("failed to marshal geofence yaml object, error: %v", err)
+	}
+	err = yaml.Unmarshal(yamlData, &settings)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal geofence yaml object, error: %v", err)
+	}
+	*c = settings
+
+	c.Operator = strings.ToLower(c.Operator)
+	if c.Operator == "" {
+		c.Operator = OperatorAnd
+	}
+	if c.Operator != OperatorAnd && c.Operator != OperatorOr {
+		return fmt.Errorf("composite geofence operator must be %s or %s, found '%s'", OperatorAnd, OperatorOr, c.Operator)
+	}
+	if len(c.GeofenceConfigs) < 2 {
+		return fmt.Errorf("composite geofence must define at least 2 geofences, found %d", len(c.GeofenceConfigs))
+	}
+
+	// trackers hold geofence state per geofence type (e.g. CurDistance for circular geofences), so each type may only be used once
+	types := map[string]bool{}
+	for i, gc := range c.GeofenceConfigs {
+		g, err := newGeofence(gc)
+		if err != nil {
+			return fmt.Errorf("unable to parse composite geofence %d, received error: %v", i, err)
+		}
+		t := fmt.Sprintf("%T", g)
+		if _, ok := g.(*CompositeGeofence); ok {
+			return fmt.Errorf("composite geofence %d cannot be another composite geofence", i)
+		}
+		if types[t] {
+			return fmt.Errorf("composite geofence %d has the same type as another geofence; each geofence type may only be used once", i)
+		}
+		types[t] = true
+		c.Geofences = append(c.Geofences, g)
+	}
+	return nil
+}
+
+// returns the state geofence for the geofence, either the geofence itself or a child of a composite geofence
+func stateGeofence(g GeofenceInterface) *StateGeofence {
+	switch g := g.(type) {
+	case *StateGeofence:
+		return g
+	case *CompositeGeofence:
+		for _, child := range g.Geofences {
+			if s := stateGeofence(child); s != nil {
+				return s
+			}
+		}
+	}
+	return nil
+}
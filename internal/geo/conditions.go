@@ -0,0 +1,172 @@
+package geo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+type (
+	// a condition that must be met before an action is executed on a garage door
+	actionCondition interface {
+		// indicates whether the action may be executed at the given time, and if not, the reason why
+		allows(action string, t time.Time) (bool, string)
+	}
+
+	// a condition based on the latest value published to an mqtt topic, e.g. an alarm panel state or a vehicle's shift state
+	// all defined comparisons must be satisfied for the condition to be met
+	TopicCondition struct {
+		Topic        string      `yaml:"topic"`                   // topic to watch on the tracker mqtt broker
+		JSONPath     string      `yaml:"json_path,omitempty"`     // optional, dot-separated path to extract the value from a json payload, e.g. state or attributes.armed
+		Equals       StateValues `yaml:"equals,omitempty"`        // optional, value must equal one of these
+		NotEquals    StateValues `yaml:"not_equals,omitempty"`    // optional, value must not equal any of these
+		Above        *float64    `yaml:"above,omitempty"`         // optional, value must be a number greater than this
+		Below        *float64    `yaml:"below,omitempty"`         // optional, value must be a number less than this
+		Actions      []string    `yaml:"actions,omitempty"`       // optional, actions the condition applies to; defaults to both open and close
+		AllowUnknown bool        `yaml:"allow_unknown,omitempty"` // optional, allow actions if no value has been received yet; defaults to false
+		value        string
+		received     bool
+		lock         sync.Mutex
+	}
+
+	// defines how automatic actions are handled after the garage door is operated manually, e.g. with a wall button or remote
+	// manual operations are only detected by openers that report the door state
+	ManualOperationSettings struct {
+		SuppressMinutes int `yaml:"suppress_minutes"` // minutes to suppress automatic actions after a manual operation is detected
+	}
+
+	// condition that suppresses actions for a period after a manual operation of the garage door
+	manualOperationCondition struct {
+		garageDoor *GarageDoor
+	}
+)
+
+// returns the conditions configured for the garage door
+func (g *GarageDoor) conditions() []actionCondition {
+	conditions := []actionCondition{&g.Schedule, &g.Sun, manualOperationCondition{g}}
+	for _, c := range g.Conditions {
+		conditions = append(conditions, c)
+	}
+	return conditions
+}
+
+// checks all of the garage door's conditions for the action, returning the reason for the first one that isn't met
+func (g *GarageDoor) checkConditions(action string, t time.Time) (bool, string) {
+	for _, c := range g.conditions() {
+		if allowed, reason := c.allows(action, t); !allowed {
+			return false, reason
+		}
+	}
+	return true, ""
+}
+
+// indicates whether the action may be executed based on when the garage door was last operated manually, and if not, the reason why
+func (c manualOperationCondition) allows(action string, t time.Time) (bool, string) {
+	suppress := time.Duration(c.garageDoor.ManualOperation.SuppressMinutes) * time.Minute
+	if suppress <= 0 {
+		return true, ""
+	}
+	lastManual := c.garageDoor.Opener.Status().LastManualOperation
+	if !lastManual.IsZero() && t.Sub(lastManual) < suppress {
+		return false, fmt.Sprintf("garage door was operated manually at %s, suppressing automatic actions for %d minutes", lastManual.Format("15:04:05"), c.garageDoor.ManualOperation.SuppressMinutes)
+	}
+	return true, ""
+}
+
+// validates the topic condition
+func (c *TopicCondition) parse() error {
+	if c.Topic == "" {
+		return fmt.Errorf("condition must define a topic")
+	}
+	// a condition has a single value, so it can't watch several topics at once
+	if strings.ContainsAny(c.Topic, "+#") {
+		return fmt.Errorf("condition topic %s must not contain the wildcards + or #", c.Topic)
+	}
+	if len(c.Equals) == 0 && len(c.NotEquals) == 0 && c.Above == nil && c.Below == nil {
+		return fmt.Errorf("condition for topic %s must define at least one of equals, not_equals, above, or below", c.Topic)
+	}
+	if len(c.Actions) == 0 {
+		c.Actions = []string{ActionOpen, ActionClose}
+	}
+	for _, a := range c.Actions {
+		if a != ActionOpen && a != ActionClose {
+			return fmt.Errorf("condition action must be %s or %s, found '%s'", ActionOpen, ActionClose, a)
+		}
+	}
+	return nil
+}
+
+// indicates whether the action may be executed based on the latest value of the condition's topic, and if not, the reason why
+func (c *TopicCondition) allows(action string, _ time.Time) (bool, string) {
+	if !containsString(c.Actions, action) {
+		return true, ""
+	}
+	c.lock.Lock()
+	value, received := c.value, c.received
+	c.lock.Unlock()
+
+	if !received {
+		if c.AllowUnknown {
+			return true, ""
+		}
+		return false, fmt.Sprintf("no value has been received for condition topic %s", c.Topic)
+	}
+	if len(c.Equals) > 0 && !containsString(c.Equals, value) {
+		return false, fmt.Sprintf("condition topic %s is '%s', expected one of %v", c.Topic, value, []string(c.Equals))
+	}
+	if containsString(c.NotEquals, value) {
+		return false, fmt.Sprintf("condition topic %s is '%s'", c.Topic, value)
+	}
+	if c.Above != nil || c.Below != nil {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false, fmt.Sprintf("condition topic %s is '%s', expected a number", c.Topic, value)
+		}
+		if c.Above != nil && number <= *c.Above {
+			return false, fmt.Sprintf("condition topic %s is %v, expected above %v", c.Topic, number, *c.Above)
+		}
+		if c.Below != nil && number >= *c.Below {
+			return false, fmt.Sprintf("condition topic %s is %v, expected below %v", c.Topic, number, *c.Below)
+		}
+	}
+	return true, ""
+}
+
+// returns the unique condition topics of all garage doors, for subscribing on the tracker mqtt broker
+func ConditionTopics() []string {
+	var topics []string
+	seen := map[string]bool{}
+	for _, g := range GarageDoors {
+		for _, c := range g.Conditions {
+			if !seen[c.Topic] {
+				seen[c.Topic] = true
+				topics = append(topics, c.Topic)
+			}
+		}
+	}
+	return topics
+}
+
+// caches the payload as the latest value of all conditions watching the topic
+func UpdateConditionTopic(topic string, payload []byte) {
+	for _, g := range GarageDoors {
+		for _, c := range g.Conditions {
+			if c.Topic != topic {
+				continue
+			}
+			value, err := extractPayloadValue(payload, c.JSONPath)
+			if err != nil {
+				logger.Errorf("could not parse message payload from condition topic %s, received error %v", topic, err)
+				continue
+			}
+			logger.Debugf("Received value for condition topic %s: %s", topic, value)
+			c.lock.Lock()
+			c.value, c.received = value, true
+			c.lock.Unlock()
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package geo
+
+import (
+	"errors"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+type (
+	// defines how long a tracker must remain on the new side of a geofence boundary before
+	// the resulting action is executed; if both are defined, satisfying either will confirm the action
+	ConfirmationSettings struct {
+		Fixes    int  `yaml:"fixes,omitempty"`     // number of consecutive location updates, including the one that crossed the boundary
+		Seconds  int  `yaml:"seconds,omitempty"`   // seconds since the boundary was crossed; re-evaluated once they've passed if no other update is received
+		WhenDark bool `yaml:"when_dark,omitempty"` // only require confirmation when it's dark at the garage, per the garage door's sun settings
+	}
+)
+
+func (c ConfirmationSettings) isDefined() bool {
+	return c.Fixes > 1 || c.Seconds > 0
+}
+
+// checks that the settings can confirm actions for the geofence; state topics are only published when the state
+// changes, so a state geofence never receives the subsequent updates needed to confirm by fixes
+func (c ConfirmationSettings) validate(g GeofenceInterface) error {
+	if _, ok := g.(*StateGeofence); ok && c.Fixes > 1 {
+		return errors.New("fixes can't confirm actions for state geofences, as their states are only published when they change; use seconds instead")
+	}
+	return nil
+}
+
+// holds an action from a geofence crossing until it's been confirmed by the garage door's confirmation settings
+// returns the action once confirmed, or an empty string if the action is still pending or was abandoned
+// because the tracker moved back across the boundary
+func (t *Tracker) confirmAction(action string) string {
+	g := t.GarageDoor
+	if !g.Confirmation.isDefined() {
+		return action
+	}
+	if g.Confirmation.WhenDark && !g.Sun.isDark(now()) {
+		t.discardPendingAction() // discard anything pending from before sunrise
+		return action
+	}
+
+	switch {
+	case action != "" && action != t.PendingAction:
+		// new boundary crossing, start confirming it
+		t.PendingAction = action
+		t.PendingActionFixes = 1
+		t.PendingActionSince = time.Now()
+		t.scheduleConfirmation()
+	case t.PendingAction == "":
+		return "" // nothing pending
+	case t.isOnPendingSide():
+		// re-evaluations once the confirmation seconds have passed aren't location updates
+		if !t.rechecking {
+			t.PendingActionFixes++
+		}
+	default:
+		logger.Debugf("Tracker %v moved back across the geofence boundary before action '%s' was confirmed, discarding it", t.ID, t.PendingAction)
+		t.discardPendingAction()
+		return ""
+	}
+
+	if (g.Confirmation.Fixes > 1 && t.PendingActionFixes >= g.Confirmation.Fixes) ||
+		(g.Confirmation.Seconds > 0 && time.Since(t.PendingActionSince) >= time.Duration(g.Confirmation.Seconds)*time.Second) {
+		logger.Debugf("Action '%s' confirmed for tracker %v after %d location updates", t.PendingAction, t.ID, t.PendingActionFixes)
+		action = t.PendingAction
+		t.discardPendingAction()
+		return action
+	}
+	logger.Debugf("Action '%s' pending confirmation for tracker %v (%d location updates so far)", t.PendingAction, t.ID, t.PendingActionFixes)
+	return ""
+}
+
+// indicates whether the tracker is still on the side of the boundary that triggered the pending action; a tracker
+// still estimated to reach the open geofence within its open_eta is on the open side, even though it's outside it
+func (t *Tracker) isOnPendingSide() bool {
+	if t.PendingAction == ActionOpen && t.WithinOpenETA {
+		return true
+	}
+	return t.GarageDoor.Geofence.isOnActionSide(t, t.PendingAction)
+}
+
+// re-evaluates the tracker through its update channel once the pending action's confirmation seconds have passed,
+// as the tracker may not publish another update in the meantime, e.g. when parked or for state geofences
+func (t *Tracker) scheduleConfirmation() {
+	if t.confirmationTimer != nil {
+		t.confirmationTimer.Stop()
+	}
+	updates := t.LocationUpdate
+	if t.GarageDoor.Confirmation.Seconds <= 0 || updates == nil {
+		return
+	}
+	t.confirmationTimer = time.AfterFunc(time.Duration(t.GarageDoor.Confirmation.Seconds)*time.Second, func() {
+		updates <- Fix{Recheck: true}
+	})
+}
+
+// clears the pending action and stops its confirmation timer, if any
+func (t *Tracker) discardPendingAction() {
+	t.PendingAction = ""
+	if t.confirmationTimer != nil {
+		t.confirmationTimer.Stop()
+		t.confirmationTimer = nil
+	}
+}
@@ -0,0 +1,178 @@
+package geo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+type (
+	// structured record of a single CheckGeofence evaluation for a tracker, explaining why an action did or didn't execute
+	Decision struct {
+		Time           time.Time      `json:"time"`
+		Door           string         `json:"door"`
+		Tracker        string         `json:"tracker"`
+		Inputs         DecisionInputs `json:"inputs"`
+		PrevMembership Membership     `json:"prev_membership"`
+		Membership     Membership     `json:"membership"`
+		Transition     string         `json:"transition,omitempty"`     // action produced by crossing a geofence boundary, before confirmation
+		PendingAction  string         `json:"pending_action,omitempty"` // action awaiting confirmation after this evaluation
+		Action         string         `json:"action,omitempty"`         // action that was evaluated against the guards
+		BlockedBy      string         `json:"blocked_by,omitempty"`     // guard that prevented the action, one of the Guard constants
+		Reason         string         `json:"reason,omitempty"`         // why the guard prevented the action
+		Executed       bool           `json:"executed"`                 // whether the action was sent to the garage door opener
+	}
+
+	// tracker details used to evaluate the geofence
+	DecisionInputs struct {
+		Location     Point    `json:"location"`
+		PrevState    string   `json:"prev_state,omitempty"` // previous state, for state geofences
+		State        string   `json:"state,omitempty"`      // current state, for state geofences
+		PrevDistance float64  `json:"prev_distance,omitempty"`
+		Distance     float64  `json:"distance,omitempty"` // distance in km from the garage, for circular geofences
+		Velocity     *float64 `json:"velocity,omitempty"`
+		Course       *float64 `json:"course,omitempty"`
+	}
+
+	// which side of the geofence boundaries a tracker is on
+	Membership struct {
+		OnOpenSide  bool `json:"on_open_side"`  // e.g. inside the open geofence
+		OnCloseSide bool `json:"on_close_side"` // e.g. outside the close geofence
+		Home        bool `json:"home"`
+	}
+)
+
+// guards that can prevent an action from executing
+const (
+	GuardInitializing = "initializing"
+	GuardConfirmation = "confirmation"
+	GuardPaused       = "paused"
+	GuardConditions   = "conditions"
+	GuardOccupancy    = "occupancy"
+	GuardLocked       = "locked"
+	GuardFlapping     = "flapping"
+)
+
+// number of decisions retained for each tracker
+const maxDecisions = 50
+
+// starts a decision for the tracker, capturing its inputs and membership before the geofence is evaluated
+func newDecision(tracker *Tracker) Decision {
+	d := Decision{
+		Time:    time.Now(),
+		Door:    tracker.GarageDoor.ID,
+		Tracker: fmt.Sprintf("%v", tracker.ID),
+		Inputs: DecisionInputs{
+			Location:     tracker.CurrentLocation,
+			PrevState:    tracker.PrevGeofence,
+			State:        tracker.CurGeofence,
+			PrevDistance: tracker.CurDistance,
+		},
+		PrevMembership: tracker.membership(),
+	}
+	if tracker.HasVelocity {
+		velocity := tracker.Velocity
+		d.Inputs.Velocity = &velocity
+	}
+	if tracker.HasCourse {
+		course := tracker.Course
+		d.Inputs.Course = &course
+	}
+	return d
+}
+
+// returns which side of the garage door's geofence boundaries the tracker is on
+func (t *Tracker) membership() Membership {
+	g := t.GarageDoor.Geofence
+	return Membership{
+		OnOpenSide:  g.isOnActionSide(t, ActionOpen),
+		OnCloseSide: g.isOnActionSide(t, ActionClose),
+		Home:        g.isHome(t),
+	}
+}
+
+// records that the guard prevented the decision's action
+func (d *Decision) block(guard, reason string) {
+	d.BlockedBy = guard
+	d.Reason = reason
+}
+
+// summarizes the decision for logging
+func (d Decision) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "door %s, tracker %s", d.Door, d.Tracker)
+	if d.Inputs.State != "" {
+		fmt.Fprintf(&b, ", state %s -> %s", d.Inputs.PrevState, d.Inputs.State)
+	} else {
+		fmt.Fprintf(&b, ", location %f,%f", d.Inputs.Location.Lat, d.Inputs.Location.Lng)
+	}
+	if d.Inputs.Distance > 0 {
+		fmt.Fprintf(&b, ", distance %.3f -> %.3f km", d.Inputs.PrevDistance, d.Inputs.Distance)
+	}
+	fmt.Fprintf(&b, ", home %t -> %t", d.PrevMembership.Home, d.Membership.Home)
+	if d.Transition != "" {
+		fmt.Fprintf(&b, ", transition %s", d.Transition)
+	}
+	if d.PendingAction != "" {
+		fmt.Fprintf(&b, ", pending %s", d.PendingAction)
+	}
+	if d.BlockedBy != "" {
+		fmt.Fprintf(&b, ", blocked by %s", d.BlockedBy)
+		if d.Reason != "" {
+			fmt.Fprintf(&b, " (%s)", d.Reason)
+		}
+	}
+	if d.Action != "" {
+		fmt.Fprintf(&b, ", action %s executed %t", d.Action, d.Executed)
+	}
+	return b.String()
+}
+
+// completes the decision with the tracker's details after the geofence was evaluated
+func (d *Decision) complete(t *Tracker) {
+	d.Inputs.Distance = t.CurDistance
+	d.Membership = t.membership()
+	d.PendingAction = t.PendingAction
+}
+
+// logs the decision and retains it for the tracker
+func (t *Tracker) recordDecision(d Decision) {
+	logger.Debugf("Decision: %s", d)
+
+	t.decisionsLock.Lock()
+	defer t.decisionsLock.Unlock()
+	t.decisions = append(t.decisions, d)
+	if len(t.decisions) > maxDecisions {
+		t.decisions = append([]Decision(nil), t.decisions[len(t.decisions)-maxDecisions:]...)
+	}
+}
+
+// returns up to the last limit decisions for the tracker, oldest first; returns all retained decisions if limit is 0
+func (t *Tracker) Decisions(limit int) []Decision {
+	t.decisionsLock.Lock()
+	defer t.decisionsLock.Unlock()
+	start := 0
+	if limit > 0 && len(t.decisions) > limit {
+		start = len(t.decisions) - limit
+	}
+	return append([]Decision{}, t.decisions[start:]...)
+}
+
+// returns up to the last limit decisions for each tracker matching the door and tracker, which match all if empty
+func Decisions(door, tracker string, limit int) []Decision {
+	decisions := []Decision{}
+	for _, g := range GarageDoors {
+		if door != "" && g.ID != door {
+			continue
+		}
+		for _, t := range g.Trackers {
+			if tracker != "" && fmt.Sprintf("%v", t.ID) != tracker {
+				continue
+			}
+			decisions = append(decisions, t.Decisions(limit)...)
+		}
+	}
+	return decisions
+}
@@ -0,0 +1,63 @@
+package geo
+
+import (
+	"math"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// returns true on the first evaluation where the tracker is approaching the open geofence and estimated to reach it
+// within eta seconds, based on its current speed and distance to the geofence in km
+// also tracks whether the tracker is currently within the eta, so the open action is only triggered once per approach
+// (see WithinOpenETA), and forgets an executed open (see OpenedByETA) once the tracker is no longer within the eta
+func (t *Tracker) enteredOpenETA(eta int, distanceToOpenGeo float64, approaching bool) bool {
+	within := false
+	if eta > 0 && approaching && t.HasVelocity && t.Velocity > 0 {
+		secondsToArrival := distanceToOpenGeo / t.Velocity * 3600
+		within = secondsToArrival <= float64(eta)
+		if within && !t.WithinOpenETA {
+			logger.Infof("Tracker %v is estimated to reach the open geofence in %.0f seconds at %.0f km/h", t.ID, secondsToArrival, t.Velocity)
+		}
+	}
+	entered := within && !t.WithinOpenETA
+	t.WithinOpenETA = within
+	if !within {
+		t.OpenedByETA = false
+	}
+	return entered
+}
+
+// returns the distance in km from the point to the nearest edge of any of the polygons' outer boundaries
+func (ps Polygons) distanceTo(p Point) float64 {
+	minDistance := math.Inf(1)
+	for _, polygon := range ps {
+		ring := polygon.Outer
+		for i := range ring {
+			d := distanceToSegment(p, ring[i], ring[(i+1)%len(ring)])
+			if d < minDistance {
+				minDistance = d
+			}
+		}
+	}
+	return minDistance
+}
+
+// returns the distance in km from the point to the line segment between a and b, using an equirectangular
+// projection centered on the point; this is accurate enough over the short distances used by geofences
+func distanceToSegment(p, a, b Point) float64 {
+	const radius = 6371 // Earth's radius in kilometers
+	cosLat := math.Cos(toRadians(p.Lat))
+	project := func(q Point) (float64, float64) {
+		return toRadians(q.Lng-p.Lng) * cosLat * radius, toRadians(q.Lat-p.Lat) * radius
+	}
+	ax, ay := project(a)
+	bx, by := project(b)
+	dx, dy := bx-ax, by-ay
+
+	// find the closest point on the segment to the origin (p)
+	var u float64
+	if lengthSquared := dx*dx + dy*dy; lengthSquared > 0 {
+		u = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/lengthSquared))
+	}
+	return math.Hypot(ax+u*dx, ay+u*dy)
+}
@@ -3,10 +3,15 @@ package geo
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/brchri/tesla-geogdo/internal/gdo"
+	"github.com/brchri/tesla-geogdo/internal/history"
 	util "github.com/brchri/tesla-geogdo/internal/util"
 	logger "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -14,43 +19,103 @@ import (
 
 type (
 	Point struct {
-		Lat float64 `yaml:"lat"`
-		Lng float64 `yaml:"lng"`
+		Lat float64 `yaml:"lat" json:"lat"`
+		Lng float64 `yaml:"lng" json:"lng"`
+	}
+
+	// a single location report received for a tracker; only the Point is required, all other
+	// fields are populated if the tracker's payload provides them (e.g. owntracks)
+	Fix struct {
+		Point
+		Accuracy    float64   // accuracy radius of the fix in meters
+		Velocity    float64   // speed in km/h
+		Course      float64   // course over ground in degrees, 0 = north
+		Altitude    float64   // altitude in meters above sea level
+		HasAccuracy bool      // indicates Accuracy was reported
+		HasVelocity bool      // indicates Velocity was reported
+		HasCourse   bool      // indicates Course was reported
+		Timestamp   time.Time // time the fix was taken, as reported by the tracker
+		State       string    // state published to the tracker's geofence topic, e.g. a teslamate geofence name
+		HasState    bool      // indicates State was reported
+		Recheck     bool      // re-evaluates the tracker without a new location or state, e.g. once a pending action's confirmation seconds have passed
 	}
 
 	Tracker struct {
 		ID                      interface{} `yaml:"id"` // mqtt identifier for vehicle
 		GarageDoor              *GarageDoor // bidirectional pointer to GarageDoor containing tracker
 		CurrentLocation         Point       // current vehicle location
-		LocationUpdate          chan Point  // channel to receive location updates
+		LocationUpdate          chan Fix    // channel to receive location updates
+		Accuracy                float64     // accuracy radius in meters of the last accepted fix, if reported
+		Velocity                float64     // last known speed in km/h, either reported by the tracker or derived from consecutive fixes
+		Course                  float64     // last known heading in degrees (0 = north), either reported by the tracker or derived from consecutive fixes
+		Altitude                float64     // altitude in meters of the last accepted fix, if reported
+		HasVelocity             bool        // indicates Velocity is known
+		HasCourse               bool        // indicates Course is known
+		LastFixTime             time.Time   // tracker-reported timestamp of the last accepted fix; used to discard stale and out-of-order fixes
+		LastLocationTime        time.Time   // timestamp of the last location update, as reported by the tracker or when it was received; used to derive speed
+		WithinOpenETA           bool        // indicates the tracker was estimated to reach the open geofence within the geofence's open_eta at the last check
+		OpenedByETA             bool        // indicates the garage was opened while WithinOpenETA, so the tracker's subsequent entry into the open geofence doesn't open it again
+		OpenGeoDistance         float64     // distance in km to the nearest polygon open geofence boundary at the last check; used to determine if the tracker is approaching
 		CurDistance             float64     // current distance from garagedoor location
-		PrevGeofence            string      // geofence previously ascribed to tracker
-		CurGeofence             string      // updated geofence ascribed to tracker when published to mqtt
+		PrevGeofence            string      // geofence or state previously ascribed to tracker
+		CurGeofence             string      // updated geofence or state ascribed to tracker when published to mqtt
 		InsidePolyOpenGeo       bool        // indicates if tracker is currently inside the polygon_open_geofence
 		InsidePolyCloseGeo      bool        // indicates if tracker is currently inside the polygon_close_geofence
 		InsidePolyRestrictedGeo bool        // indicates if tracker is currently inside the polygon_restricted_geofence
 		LastEnteredCloseGeo     time.Time   // timestamp of when tracker last entered the close geofence; used to prevent flapping
 		LastLeftOpenGeo         time.Time   // timestamp of when tracker last left the open geofence; used to prevent flapping
-		LatTopic                string      `yaml:"lat_topic"`
-		LngTopic                string      `yaml:"lng_topic"`
-		GeofenceTopic           string      `yaml:"geofence_topic"` // topic for publishing a geofence name where a tracker resides, e.g. teslamate geofence indicating 'home' or 'not_home'
+		PendingAction           string      // action awaiting confirmation per the garage door's confirmation settings
+		PendingActionFixes      int         // number of consecutive location updates the tracker has remained on the PendingAction side of the boundary
+		PendingActionSince      time.Time   // timestamp of when the boundary for PendingAction was crossed
+		confirmationTimer       *time.Timer // re-evaluates the tracker once PendingAction's confirmation seconds have passed
+		rechecking              bool        // indicates the tracker is being re-evaluated without a new location or state, so the evaluation doesn't count towards PendingActionFixes
+		LastUpdate              time.Time   // timestamp of the last location or state update checked against the geofence
+		initialized             bool        // indicates the tracker's geofence membership has been seeded from its first location or state
+		restored                bool        // indicates the tracker's geofence membership was restored from persisted state, and not yet compared with its first update
+		decisions               []Decision  // most recent CheckGeofence evaluations for the tracker
+		decisionsLock           sync.Mutex
+		LatTopic                string `yaml:"lat_topic"`
+		LngTopic                string `yaml:"lng_topic"`
+		PairingWindow           int    `yaml:"pairing_window"` // seconds to wait for the matching lat or lng when they're published to separate topics; 0 disables pairing
+		GeofenceTopic           string `yaml:"geofence_topic"` // topic for publishing a geofence name or state for the tracker, e.g. teslamate geofence indicating 'home' or 'not_home'
+		HeadingTopic            string `yaml:"heading_topic"`  // optional topic for publishing the tracker's heading in degrees, e.g. teslamate/cars/1/heading
+		SpeedTopic              string `yaml:"speed_topic"`    // optional topic for publishing the tracker's speed in km/h, e.g. teslamate/cars/1/speed
 		ComplexTopic            struct {
 			Topic      string `yaml:"topic"`
 			LatJsonKey string `yaml:"lat_json_key"`
 			LngJsonKey string `yaml:"lng_json_key"`
 		} `yaml:"complex_topic"`
+		OwnTracks     OwnTracksSettings `yaml:"owntracks"` // native owntracks location topic, used instead of lat/lng or complex topics
+		lastHalfPoint atomic.Int64      // unix nanoseconds of when a lone lat or lng was last emitted after the pairing window expired
+
+		partialLocation bool // the current location was last updated by a lone lat or lng, so mixes coordinates from different times
 	}
 
-	// defines a garage door with one unique geofence type: circular, teslamate, or polygon
+	// defines a garage door with one unique geofence type: circular, polygon, state, teslamate (a preset of state),
+	// or composite (combining multiple other types)
 	// only one geofence type may be defined per garage door
-	// if more than one defined, priority will be polygon > circular > teslamate
 	GarageDoor struct {
-		Geofence       GeofenceInterface      `yaml:"-"` // geofence; don't parse this from the geofence yaml
-		Opener         gdo.GDO                `yaml:"-"` // garage door opener; don't parse this from the garage door yaml
-		GeofenceConfig map[string]interface{} `yaml:"geofence"`
-		OpenerConfig   map[string]interface{} `yaml:"opener"`   // holds gdo config that is parsed on gdo.Initialize
-		Trackers       []*Tracker             `yaml:"trackers"` // trackers housed within this garage
-		OpLock         bool                   // controls if garagedoor has been operated recently to prevent flapping
+		ID              string                  `yaml:"id"`   // optional, unique identifier used in logs, the api, pauses, and history; defaults to the garage door's position in the config, starting at 0
+		Name            string                  `yaml:"name"` // optional, unique friendly name used in logs
+		Geofence        GeofenceInterface       `yaml:"-"`    // geofence; don't parse this from the geofence yaml
+		Opener          gdo.GDO                 `yaml:"-"`    // garage door opener; don't parse this from the garage door yaml
+		GeofenceConfig  map[string]interface{}  `yaml:"geofence"`
+		OpenerConfig    map[string]interface{}  `yaml:"opener"`           // holds gdo config that is parsed on gdo.Initialize
+		Trackers        []*Tracker              `yaml:"trackers"`         // trackers housed within this garage
+		Confirmation    ConfirmationSettings    `yaml:"confirmation"`     // optional, require a tracker to remain across a geofence boundary before acting
+		Occupancy       OccupancySettings       `yaml:"occupancy"`        // optional, consider other trackers sharing this garage door before acting
+		Schedule        ScheduleSettings        `yaml:"schedule"`         // optional, restrict automatic actions to certain days and times
+		Sun             SunSettings             `yaml:"sun"`              // optional, restrict automatic actions to day or night at the garage
+		Conditions      []*TopicCondition       `yaml:"conditions"`       // optional, restrict automatic actions based on the latest values of mqtt topics
+		ManualOperation ManualOperationSettings `yaml:"manual_operation"` // optional, suppress automatic actions after the garage door is operated manually
+		Startup         StartupSettings         `yaml:"startup"`          // optional, reconcile the garage door with its trackers' locations on startup
+		OpLock          atomic.Bool             // controls if garagedoor has been operated recently to prevent flapping; acquire with tryLock
+		LastOperation   time.Time               // timestamp of the last attempt to operate the garage door; used to restore the cooldown after a restart; guarded by statusLock
+		reconcileOnce   sync.Once               // ensures the garage door is only reconciled once on startup
+		lastResult      *ActionResult           // outcome of the last attempt to operate the garage door
+		cooldownUntil   time.Time               // time the cooldown following the last operation ends
+		statusLock      sync.Mutex
+		evalLock        sync.Mutex // held while the garage door's trackers are updated and evaluated, see ProcessFix
 	}
 
 	// interface to represent geofence object
@@ -59,6 +124,14 @@ type (
 		// determines if a tracker is currently within a geofence, if it was previously,
 		// and what action should be taken if those are different (indicating a crossing of geofences)
 		getEventChangeAction(*Tracker) string
+		// indicates whether the tracker is currently on the side of the geofence boundary that triggers the action,
+		// e.g. inside the open geofence for an open action, or outside the close geofence for a close action
+		isOnActionSide(*Tracker, string) bool
+		// indicates whether the tracker is considered to be at the garage, i.e. inside the close geofence,
+		// or inside the open geofence if no close geofence is defined
+		isHome(*Tracker) bool
+		// indicates whether the tracker's membership of the geofence has been seeded by a location or state it applies to
+		isSeeded(*Tracker) bool
 		// parse the settings: of a geofence into the specific geofence type struct
 		parseSettings(map[string]interface{}) error
 	}
@@ -69,9 +142,14 @@ const (
 	ActionClose = "close"
 )
 
+// how long after leaving the open geofence or entering the close geofence a tracker may be flapping across the boundary
+const flapWindow = 10 * time.Second
+
 var (
 	GarageDoors       []*GarageDoor
 	InitializeGdoFunc = gdo.Initialize // abstract gdo.Initialize function call to allow mocking
+	now               = time.Now       // abstract the current time used to check conditions to allow deterministic tests
+	validGarageDoorID = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
 )
 
 func init() {
@@ -87,21 +165,149 @@ func (p Point) IsPointDefined() bool {
 	return p.Lat != 0 && p.Lng != 0
 }
 
+// applies a location fix to the tracker, updating its current location and any reported
+// accuracy, velocity, course, and timestamp details, or its current state if the fix reports one
+// if the fix doesn't report a course or velocity and the tracker has no heading or speed topic,
+// they're derived from the tracker's previous location
+// returns false if the fix was discarded or the tracker's location is still incomplete,
+// in which case there's no need to check the geofence
+func (t *Tracker) ApplyFix(f Fix) bool {
+	if err := t.ValidateFix(f); err != nil {
+		logger.Debugf("Discarding location update for tracker %v: %v", t.ID, err)
+		return false
+	}
+
+	t.rechecking = f.Recheck
+	if f.HasState {
+		t.PrevGeofence = t.CurGeofence
+		t.CurGeofence = f.State
+		return true
+	}
+	// the state didn't change with this fix, so the last state change must not trigger again
+	t.PrevGeofence = t.CurGeofence
+	if f.Recheck {
+		return true
+	}
+
+	if f.HasVelocity {
+		t.Velocity, t.HasVelocity = f.Velocity, true
+	}
+	if f.HasCourse {
+		t.Course, t.HasCourse = f.Course, true
+	}
+
+	prevLocation := t.CurrentLocation
+	var newLocation bool
+	if f.Lat != 0 {
+		t.CurrentLocation.Lat = f.Lat
+		newLocation = true
+	}
+	if f.Lng != 0 {
+		t.CurrentLocation.Lng = f.Lng
+		newLocation = true
+	}
+	if !newLocation {
+		return false
+	}
+
+	if f.HasAccuracy {
+		t.Accuracy = f.Accuracy
+	}
+	t.Altitude = f.Altitude
+	locationTime := time.Now()
+	if !f.Timestamp.IsZero() {
+		t.LastFixTime = f.Timestamp
+		locationTime = f.Timestamp
+	}
+	// only derive speed and heading between complete locations; a lone lat or lng moves the location along
+	// one axis only, which would produce wildly inaccurate speeds and headings
+	derive := f.IsPointDefined() && !t.partialLocation && prevLocation.IsPointDefined()
+	t.partialLocation = !f.IsPointDefined()
+	// only derive speed over at least 1 second, otherwise updates received milliseconds apart
+	// will produce wildly inaccurate speeds
+	if derive && !f.HasVelocity && t.SpeedTopic == "" && !t.LastLocationTime.IsZero() {
+		if elapsed := locationTime.Sub(t.LastLocationTime); elapsed >= time.Second {
+			t.Velocity, t.HasVelocity = distance(prevLocation, t.CurrentLocation)/elapsed.Hours(), true
+		}
+	}
+	t.LastLocationTime = locationTime
+	if derive && !f.HasCourse && t.HeadingTopic == "" && distance(prevLocation, t.CurrentLocation) >= minHeadingDistance {
+		t.Course, t.HasCourse = bearing(prevLocation, t.CurrentLocation), true
+	}
+
+	return t.CurrentLocation.IsPointDefined()
+}
+
+// applies the fix to the tracker and checks its geofence if needed; holds the garage door's lock so its trackers are
+// evaluated one at a time, and their state can be read from other goroutines, e.g. by SaveState and Status
+func ProcessFix(tracker *Tracker, f Fix) {
+	tracker.GarageDoor.evalLock.Lock()
+	defer tracker.GarageDoor.evalLock.Unlock()
+	if tracker.ApplyFix(f) {
+		CheckGeofence(tracker)
+	}
+}
+
 // check if outside close geo or inside open geo and set garage door state accordingly
-func CheckGeofence(tracker *Tracker) {
+// returns a record of the evaluation explaining why an action did or didn't execute
+func CheckGeofence(tracker *Tracker) (d Decision) {
+	recordEvent(tracker, history.Event{Type: history.TypeFix, Lat: tracker.CurrentLocation.Lat, Lng: tracker.CurrentLocation.Lng, Geofence: tracker.CurGeofence})
+	tracker.LastUpdate = time.Now()
+	d = newDecision(tracker)
+	defer func() {
+		d.complete(tracker)
+		tracker.recordDecision(d)
+	}()
+
+	// the first update after startup only seeds the tracker's geofence membership
+	if !tracker.initialized {
+		tracker.initialize()
+		d.block(GuardInitializing, "first update since startup only determines the tracker's geofence membership")
+		tracker.GarageDoor.reconcile(tracker, &d)
+		return
+	}
 
 	// get action based on either geo cross events or distance threshold cross events
 	action := tracker.GarageDoor.Geofence.getEventChangeAction(tracker)
+	if action != "" {
+		d.Transition = action
+		recordEvent(tracker, history.Event{Type: history.TypeTransition, Action: action})
+	}
+	// hold the action if the garage door requires it to be confirmed by subsequent location updates
+	action = tracker.confirmAction(action)
 
 	if action == "" {
+		if tracker.PendingAction != "" {
+			d.block(GuardConfirmation, "waiting for subsequent location updates to confirm the action")
+		}
 		return // nothing to do
 	}
-	if util.Config.MasterOpLock != 0 {
-		logger.Warnf("Garage operations are currently paused due to user request, will not execute action '%s'. Use /resume api endpoint to resume garage operations", action)
+	d.Action = action
+	executeAction(tracker, action, &d)
+	return
+}
+
+// executes the action on the tracker's garage door unless operations are paused, a condition isn't met,
+// the garage door is occupied or locked, or the tracker may be flapping across the geofence boundary;
+// the outcome is recorded in the decision
+func executeAction(tracker *Tracker, action string, d *Decision) {
+	suppress := func(guard, reason string) {
+		d.block(guard, reason)
+		recordSuppressed(tracker, action, reason)
+	}
+	if p := tracker.pause(); p != nil {
+		logger.Warnf("Garage operations are currently paused for %s due to user request, will not execute action '%s' for tracker %v. Use /resume api endpoint to resume garage operations", p.PauseScope, action, tracker.ID)
+		suppress(GuardPaused, fmt.Sprintf("garage operations are paused for %s", p.PauseScope))
 		return
 	}
-	if tracker.GarageDoor.OpLock {
-		logger.Debugf("Garage operation is locked (due to either cooldown or current activity), will not execute action '%s'", action)
+	if allowed, reason := tracker.GarageDoor.checkConditions(action, now()); !allowed {
+		logger.Infof("Will not execute action '%s' for tracker %v: %s", action, tracker.ID, reason)
+		suppress(GuardConditions, reason)
+		return
+	}
+	if occupants := tracker.GarageDoor.occupancyBlockers(tracker, action); len(occupants) > 0 {
+		logger.Infof("Garage door %s is occupied by tracker(s) %v, will not execute action '%s' for tracker %v", tracker.GarageDoor, occupants, action, tracker.ID)
+		suppress(GuardOccupancy, fmt.Sprintf("garage door is occupied by tracker(s) %v", occupants))
 		return
 	}
 	// check if tracker geofence event is valid to prevent flapping
@@ -116,23 +322,56 @@ func CheckGeofence(tracker *Tracker) {
 			geofence = "close"
 		}
 		logger.Debugf("Tracker just recently %s the %s geofence, indicating a possible flap; will not execute action %s", geofenceEvent, geofence, action)
+		suppress(GuardFlapping, fmt.Sprintf("tracker just recently %s the %s geofence, indicating a possible flap", geofenceEvent, geofence))
+		return
+	}
+	// acquired last so the lock is only taken if the action will be executed
+	if !tracker.GarageDoor.tryLock() {
+		logger.Debugf("Garage operation is locked (due to either cooldown or current activity), will not execute action '%s'", action)
+		suppress(GuardLocked, "garage door is locked due to either cooldown or current activity")
 		return
 	}
 
-	tracker.GarageDoor.OpLock = true // set lock so no other threads try to operate the garage before the cooldown period is complete
-	// send operation to garage door and wait for timeout to release oplock
-	// run as goroutine to prevent blocking update channels from mqtt broker in main
-	go func() {
-		switch tracker.GarageDoor.Geofence.(type) {
-		case *TeslamateGeofence:
-			logger.Infof("Attempting to %s garage door for tracker %v", action, tracker.ID)
+	d.Executed = true
+	if action == ActionOpen && tracker.WithinOpenETA {
+		// only an executed open consumes the estimated arrival; if it was held or blocked, entering the open geofence opens as usual
+		tracker.OpenedByETA = true
+	}
+	tracker.GarageDoor.operate(action, tracker, nil)
+}
+
+// acquires the garage door's operation lock if it isn't already held, so no other threads try to operate the garage
+// before the cooldown period is complete; returns false if it's already held
+func (g *GarageDoor) tryLock() bool {
+	return g.OpLock.CompareAndSwap(false, true)
+}
+
+// operates the garage door, retrying on failure, then holds the OpLock through the cooldown; the caller must have
+// acquired the OpLock with tryLock; the tracker is the tracker that triggered the action, or nil for commands,
+// and done, if not nil, receives the opener's result
+func (g *GarageDoor) operate(action string, tracker *Tracker, done func(error)) {
+	// logged before starting the goroutine, as the tracker may be updated in the meantime
+	if tracker == nil {
+		logger.Infof("Attempting to %s garage door %s by command", action, g)
+	} else {
+		switch g.Geofence.(type) {
+		case *StateGeofence:
+			logger.Infof("Attempting to %s garage door %s for tracker %v", action, g, tracker.ID)
 		default:
-			logger.Infof("Attempting to %s garage door for tracker %v at lat %f, long %f", action, tracker.ID, tracker.CurrentLocation.Lat, tracker.CurrentLocation.Lng)
+			logger.Infof("Attempting to %s garage door %s for tracker %v at lat %f, long %f", action, g, tracker.ID, tracker.CurrentLocation.Lat, tracker.CurrentLocation.Lng)
 		}
+	}
 
+	// send operation to garage door and wait for timeout to release oplock
+	// run as goroutine to prevent blocking update channels from mqtt broker in main
+	go func() {
+		g.statusLock.Lock()
+		g.LastOperation = time.Now()
+		g.statusLock.Unlock()
 		// create retry loop to set the garage door state
+		var err error
 		for i := 3; i > 0; i-- {
-			err := tracker.GarageDoor.Opener.SetGarageDoor(action)
+			err = g.Opener.SetGarageDoor(action)
 			if err == nil {
 				// no error received, so breaking retry loop)
 				break
@@ -144,16 +383,24 @@ func CheckGeofence(tracker *Tracker) {
 				logger.Warnf("Retrying set garage door state %d more time(s)", i-1)
 			}
 		}
+		recordResult(g, tracker, action, err)
+		g.setLastResult(tracker, action, err)
+		if done != nil {
+			done(err)
+		}
 
 		if util.Config.Global.OpCooldown > 0 {
-			time.Sleep(time.Duration(util.Config.Global.OpCooldown) * time.Minute) // keep opLock true for OpCooldown minutes to prevent flapping in case of overlapping geofences
-		} else if os.Getenv("GDO_SKIP_FLAP_DELAY") != "true" {
-			// because lat and long are processed individually, it's possible that a tracker may flap briefly on the geofence crossing which can spam action calls to the gdo
+			cooldown := time.Duration(util.Config.Global.OpCooldown) * time.Minute
+			g.setCooldownUntil(time.Now().Add(cooldown))
+			time.Sleep(cooldown) // keep opLock true for OpCooldown minutes to prevent flapping in case of overlapping geofences
+		} else if os.Getenv("GDO_SKIP_FLAP_DELAY") != "true" && !g.receivesAtomicFixes() {
+			// because lat and long may be processed individually, it's possible that a tracker may flap briefly on the geofence crossing which can spam action calls to the gdo
 			// add a small sleep to prevent this
-			logger.Debugf("Garage for tracker %v retaining oplock for 5s to mitigate flapping when crossing geofence...", tracker.ID)
+			logger.Debugf("Garage door %s retaining oplock for 5s to mitigate flapping when crossing geofence...", g)
+			g.setCooldownUntil(time.Now().Add(5000 * time.Millisecond))
 			time.Sleep(5000 * time.Millisecond)
 		}
-		tracker.GarageDoor.OpLock = false // release garage door's operation lock
+		g.OpLock.Store(false) // release garage door's operation lock
 	}()
 }
 
@@ -166,9 +413,11 @@ func CheckGeofence(tracker *Tracker) {
 // which would incorrectly trigger an open event; by checking that we didn't just recently leave the open geofence we
 // can avoid this behavior by accounting for possible "teleporting" when doing a geofence event change
 //
+// trackers that only process complete lat/lng pairs (see receivesAtomicFixes) can't teleport, so are always cleared
+//
 // geofences must implement setting the LastLeftOpenGeo and LastEnteredCloseGeo for this to be effective
 func isClearedFromFlapping(action string, tracker *Tracker) bool {
-	if os.Getenv("GDO_SKIP_FLAP_DELAY") == "true" {
+	if os.Getenv("GDO_SKIP_FLAP_DELAY") == "true" || tracker.receivesAtomicFixes() {
 		return true
 	}
 	var compareTime time.Time
@@ -177,7 +426,17 @@ func isClearedFromFlapping(action string, tracker *Tracker) bool {
 	} else {
 		compareTime = tracker.LastEnteredCloseGeo
 	}
-	return time.Since(compareTime).Seconds() >= 10
+	return time.Since(compareTime) >= flapWindow
+}
+
+// indicates whether all trackers for the garage door only process complete lat/lng pairs
+func (g *GarageDoor) receivesAtomicFixes() bool {
+	for _, t := range g.Trackers {
+		if !t.receivesAtomicFixes() {
+			return false
+		}
+	}
+	return true
 }
 
 func ParseGarageDoorConfig() {
@@ -195,14 +454,38 @@ func ParseGarageDoorConfig() {
 	if len(GarageDoors) == 0 {
 		logger.Fatal("Unable to find garage doors in config! Please ensure proper spacing in the config file")
 	}
-	for i, g := range GarageDoors {
+	if err = parseGarageDoorIDs(GarageDoors); err != nil {
+		logger.Fatalf("unable to parse garage door ids, received error: %v", err)
+	}
+	for _, g := range GarageDoors {
 		if len(g.Trackers) == 0 {
-			logger.Fatalf("No trackers found for garage door #%d! Please ensure proper spacing in the config file", i)
+			logger.Fatalf("No trackers found for garage door %s! Please ensure proper spacing in the config file", g)
 		}
 
 		g.Geofence, err = newGeofence(g.GeofenceConfig)
 		if err != nil {
-			logger.Fatalf("unable to parse geofence config for door %d, received error: %v", i, err)
+			logger.Fatalf("unable to parse geofence config for garage door %s, received error: %v", g, err)
+		}
+
+		if err = g.Schedule.parse(); err != nil {
+			logger.Fatalf("unable to parse schedule for garage door %s, received error: %v", g, err)
+		}
+		if err = g.Sun.parse(g.Geofence); err != nil {
+			logger.Fatalf("unable to parse sun settings for garage door %s, received error: %v", g, err)
+		}
+		for _, c := range g.Conditions {
+			if err = c.parse(); err != nil {
+				logger.Fatalf("unable to parse conditions for garage door %s, received error: %v", g, err)
+			}
+		}
+		if err = g.Startup.validate(); err != nil {
+			logger.Fatalf("unable to parse startup settings for garage door %s, received error: %v", g, err)
+		}
+		if err = g.Confirmation.validate(g.Geofence); err != nil {
+			logger.Fatalf("unable to parse confirmation settings for garage door %s, received error: %v", g, err)
+		}
+		if g.Confirmation.WhenDark && !g.Sun.Location.IsPointDefined() {
+			logger.Fatalf("confirmation for garage door %s is only required when dark, but the garage location is unknown; please define sun.location", g)
 		}
 
 		g.Opener, err = InitializeGdoFunc(g.OpenerConfig)
@@ -212,11 +495,49 @@ func ParseGarageDoorConfig() {
 
 		// initialize location update channel
 		for _, c := range g.Trackers {
-			c.LocationUpdate = make(chan Point)
+			c.LocationUpdate = make(chan Fix)
+			// trackers watch the state geofence's topic unless they define their own
+			if s := stateGeofence(g.Geofence); s != nil && c.GeofenceTopic == "" {
+				c.GeofenceTopic = s.Topic
+			}
 		}
 	}
 }
 
+// defaults each garage door's id to its position in the config, and checks that ids and names are unique;
+// ids are used in api paths and mqtt topics, so may only contain letters, numbers, '-', '_', and '.'
+func parseGarageDoorIDs(doors []*GarageDoor) error {
+	ids := map[string]bool{}
+	names := map[string]bool{}
+	for i, g := range doors {
+		if g.ID == "" {
+			g.ID = strconv.Itoa(i)
+		} else if !validGarageDoorID.MatchString(g.ID) {
+			return fmt.Errorf("garage door id '%s' may only contain letters, numbers, '-', '_', and '.'", g.ID)
+		}
+		if ids[g.ID] {
+			return fmt.Errorf("garage door id '%s' is not unique; note garage doors without an id default to their position in the config, starting at 0", g.ID)
+		}
+		ids[g.ID] = true
+		if g.Name == "" {
+			continue
+		}
+		if names[strings.ToLower(g.Name)] {
+			return fmt.Errorf("garage door name '%s' is not unique", g.Name)
+		}
+		names[strings.ToLower(g.Name)] = true
+	}
+	return nil
+}
+
+// returns the garage door's name, or its id if it has no name
+func (g *GarageDoor) String() string {
+	if g.Name != "" {
+		return g.Name
+	}
+	return g.ID
+}
+
 // return a new instance of a GeofenceInterface based on the type defined in the config yml
 func newGeofence(config map[string]interface{}) (GeofenceInterface, error) {
 	type geofenceConfig struct {
@@ -237,10 +558,14 @@ func newGeofence(config map[string]interface{}) (GeofenceInterface, error) {
 	switch geoConfig.GeofenceType {
 	case "circular":
 		g = &CircularGeofence{}
+	case "state":
+		g = &StateGeofence{}
 	case "teslamate":
-		g = &TeslamateGeofence{}
+		g = &StateGeofence{requireFrom: true}
 	case "polygon":
 		g = &PolygonGeofence{}
+	case "composite":
+		g = &CompositeGeofence{}
 	default:
 		return nil, fmt.Errorf("unable to parse geofence config type %s", geoConfig.GeofenceType)
 	}
@@ -1,14 +1,20 @@
 package geo
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/brchri/tesla-geogdo/internal/gdo"
+	"github.com/brchri/tesla-geogdo/internal/gdo/state"
+	"github.com/brchri/tesla-geogdo/internal/history"
 	"github.com/brchri/tesla-geogdo/internal/mocks"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
 
 	"github.com/brchri/tesla-geogdo/internal/util"
 )
@@ -50,6 +56,13 @@ func init() {
 	polygonTracker.GarageDoor = polygonGarageDoor
 	polygonGeofence, _ = polygonTracker.GarageDoor.Geofence.(*PolygonGeofence) // type cast geofence interface
 
+	// tests set the trackers' geofence membership explicitly, so skip seeding it from their first update
+	for _, g := range GarageDoors {
+		for _, t := range g.Trackers {
+			t.initialized = true
+		}
+	}
+
 	util.Config.Global.OpCooldown = 0
 
 	os.Setenv("GDO_SKIP_FLAP_DELAY", "true") // for testing, skip 1.5s delay after gdo ops meant to prevent spam from flapping
@@ -87,14 +100,14 @@ func Test_isInsidePolygonGeo(t *testing.T) {
 		Lng: -123.79984989897177,
 	}
 
-	assert.Equal(t, false, isInsidePolygonGeo(p, polygonGeofence.Close))
+	assert.Equal(t, false, polygonGeofence.Close.contains(p))
 
 	p = Point{
 		Lat: 46.19243683948096,
 		Lng: -123.80103692981524,
 	}
 
-	assert.Equal(t, true, isInsidePolygonGeo(p, polygonGeofence.Open))
+	assert.Equal(t, true, polygonGeofence.Open.contains(p))
 }
 
 func Test_getEventAction_Polygon(t *testing.T) {
@@ -174,7 +187,7 @@ func Test_CheckCircularGeofence_LeaveThenArrive(t *testing.T) {
 	CheckGeofence(distanceTracker)
 	// wait for oplock to release to ensure goroutine within CheckGeofence function has completed
 	for {
-		if !distanceTracker.GarageDoor.OpLock {
+		if !distanceTracker.GarageDoor.OpLock.Load() {
 			break
 		}
 	}
@@ -233,6 +246,36 @@ func Test_CheckTeslamateGeofence_Arriving(t *testing.T) {
 	assert.Equal(t, checkGeofenceWrapper(teslamateTracker), true)
 }
 
+func Test_CheckTeslamateGeofence_Confirmation(t *testing.T) {
+	mockGdo := &mocks.GDO{}
+	teslamateTracker.GarageDoor.Opener = mockGdo
+	defer mockGdo.AssertExpectations(t)
+
+	// state topics are only published on change, so fixes can't confirm state actions
+	assert.NotNil(t, ConfirmationSettings{Fixes: 2}.validate(teslamateGeofence))
+	assert.Nil(t, ConfirmationSettings{Seconds: 1}.validate(teslamateGeofence))
+
+	teslamateGarageDoor.Confirmation = ConfirmationSettings{Seconds: 1}
+	defer func() { teslamateGarageDoor.Confirmation = ConfirmationSettings{} }() // restore settings
+
+	// leaving home is held pending confirmation
+	teslamateTracker.PrevGeofence = "home"
+	teslamateTracker.CurGeofence = "not_home"
+	assert.Equal(t, true, checkGeofenceWrapper(teslamateTracker))
+	assert.Equal(t, ActionClose, teslamateTracker.PendingAction)
+
+	// no further state is published, but the tracker is re-evaluated once the confirmation seconds have passed
+	mockGdo.EXPECT().SetGarageDoor(ActionClose).Return(nil).Once()
+	select {
+	case f := <-teslamateTracker.LocationUpdate:
+		assert.Equal(t, true, teslamateTracker.ApplyFix(f))
+		assert.Equal(t, true, checkGeofenceWrapper(teslamateTracker))
+	case <-time.After(2 * time.Second):
+		t.Error("tracker was not re-evaluated after the confirmation seconds passed")
+	}
+	assert.Equal(t, "", teslamateTracker.PendingAction)
+}
+
 func Test_CheckPolyGeofence_Leaving(t *testing.T) {
 	mockGdo := &mocks.GDO{}
 	polygonTracker.GarageDoor.Opener = mockGdo
@@ -302,7 +345,7 @@ func Test_CheckPolyGeofence_Leaving_NoClose(t *testing.T) {
 	defer mockGdo.AssertExpectations(t)
 
 	prevCloseGeofence := polygonGeofence.Close
-	polygonGeofence.Close = []Point{}
+	polygonGeofence.Close = Polygons{}
 
 	polygonTracker.InsidePolyCloseGeo = true
 	polygonTracker.InsidePolyOpenGeo = true
@@ -313,16 +356,1469 @@ func Test_CheckPolyGeofence_Leaving_NoClose(t *testing.T) {
 	polygonGeofence.Close = prevCloseGeofence // restore settings
 }
 
+func Test_ParseOwnTracksPayload(t *testing.T) {
+	fix, err := ParseOwnTracksPayload([]byte(`{"_type":"location","lat":46.19243,"lon":-123.80103,"acc":12,"vel":34,"cog":270,"alt":15,"tst":1700000000}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 46.19243, fix.Lat)
+	assert.Equal(t, -123.80103, fix.Lng)
+	assert.Equal(t, float64(12), fix.Accuracy)
+	assert.Equal(t, float64(34), fix.Velocity)
+	assert.Equal(t, float64(270), fix.Course)
+	assert.Equal(t, true, fix.HasVelocity)
+	assert.Equal(t, true, fix.HasCourse)
+	assert.Equal(t, time.Unix(1700000000, 0), fix.Timestamp)
+
+	// negative cog indicates unknown course
+	fix, err = ParseOwnTracksPayload([]byte(`{"_type":"location","lat":46.19243,"lon":-123.80103,"cog":-1}`))
+	assert.Nil(t, err)
+	assert.Equal(t, false, fix.HasCourse)
+	assert.Equal(t, false, fix.HasVelocity)
+
+	// non-location payloads are ignored
+	fix, err = ParseOwnTracksPayload([]byte(`{"_type":"lwt","tst":1700000000}`))
+	assert.Nil(t, err)
+	assert.Equal(t, Point{}, fix.Point)
+
+	_, err = ParseOwnTracksPayload([]byte(`{"_type":"location","acc":12}`))
+	assert.NotNil(t, err)
+}
+
+func Test_ValidateFix(t *testing.T) {
+	tracker := &Tracker{ID: "owntracks"}
+	tracker.OwnTracks.MaxAccuracy = 50
+	tracker.OwnTracks.MaxAge = 300
+
+	now := time.Now()
+	fix := Fix{Point: Point{Lat: 46.19243, Lng: -123.80103}, Accuracy: 10, HasAccuracy: true, Timestamp: now}
+	assert.Nil(t, tracker.ValidateFix(fix))
+	assert.Equal(t, true, tracker.ApplyFix(fix))
+	assert.Equal(t, now, tracker.LastFixTime)
+
+	// out-of-order fix
+	fix.Timestamp = now.Add(-10 * time.Second)
+	assert.NotNil(t, tracker.ValidateFix(fix))
+	assert.Equal(t, false, tracker.ApplyFix(fix))
+
+	// stale fix
+	tracker.LastFixTime = time.Time{}
+	fix.Timestamp = now.Add(-10 * time.Minute)
+	assert.NotNil(t, tracker.ValidateFix(fix))
+
+	// inaccurate fix
+	fix.Timestamp = now
+	fix.Accuracy = 100
+	assert.NotNil(t, tracker.ValidateFix(fix))
+
+	// fixes without owntracks details are always valid
+	assert.Nil(t, tracker.ValidateFix(Fix{Point: Point{Lat: 46.19243}}))
+}
+
+func Test_PairLocationUpdates(t *testing.T) {
+	tracker := &Tracker{
+		ID:             "paired",
+		LatTopic:       "lat",
+		LngTopic:       "lng",
+		PairingWindow:  1,
+		LocationUpdate: make(chan Fix),
+	}
+	paired := tracker.PairLocationUpdates()
+
+	// lat and lng halves are emitted as a single point
+	tracker.LocationUpdate <- Fix{Point: Point{Lat: 46.19243}}
+	tracker.LocationUpdate <- Fix{Point: Point{Lng: -123.80103}}
+	assert.Equal(t, Point{Lat: 46.19243, Lng: -123.80103}, (<-paired).Point)
+	assert.Equal(t, true, tracker.receivesAtomicFixes())
+
+	// a lone half is emitted once the pairing window expires, after which the tracker may teleport
+	tracker.LocationUpdate <- Fix{Point: Point{Lat: 46.19292}}
+	select {
+	case f := <-paired:
+		assert.Equal(t, Point{Lat: 46.19292}, f.Point)
+	case <-time.After(2 * time.Second):
+		t.Error("half-point was not emitted after pairing window expired")
+	}
+	assert.Equal(t, false, tracker.receivesAtomicFixes())
+	tracker.lastHalfPoint.Store(time.Now().Add(-flapWindow).UnixNano())
+	assert.Equal(t, true, tracker.receivesAtomicFixes())
+
+	close(tracker.LocationUpdate)
+	_, ok := <-paired
+	assert.Equal(t, false, ok)
+
+	// without a pairing window, updates are passed through
+	tracker.PairingWindow = 0
+	assert.Equal(t, (<-chan Fix)(tracker.LocationUpdate), tracker.PairLocationUpdates())
+}
+
+func Test_confirmAction(t *testing.T) {
+	distanceGarageDoor.Confirmation = ConfirmationSettings{Fixes: 2}
+	defer func() { distanceGarageDoor.Confirmation = ConfirmationSettings{} }() // restore settings
+
+	// leaving; first fix outside close geofence is held pending confirmation
+	distanceTracker.CurDistance = 0
+	distanceTracker.CurrentLocation.Lat = distanceGeofence.Center.Lat + 10
+	distanceTracker.CurrentLocation.Lng = distanceGeofence.Center.Lng
+	assert.Equal(t, "", distanceTracker.confirmAction(distanceGeofence.getEventChangeAction(distanceTracker)))
+	assert.Equal(t, ActionClose, distanceTracker.PendingAction)
+
+	// a re-evaluation without a new location update isn't counted towards the fixes
+	assert.Equal(t, true, distanceTracker.ApplyFix(Fix{Recheck: true}))
+	assert.Equal(t, "", distanceTracker.confirmAction(distanceGeofence.getEventChangeAction(distanceTracker)))
+	assert.Equal(t, 1, distanceTracker.PendingActionFixes)
+
+	// second consecutive fix outside close geofence confirms the action
+	assert.Equal(t, true, distanceTracker.ApplyFix(Fix{Point: Point{Lat: distanceGeofence.Center.Lat + 11, Lng: distanceGeofence.Center.Lng}}))
+	assert.Equal(t, ActionClose, distanceTracker.confirmAction(distanceGeofence.getEventChangeAction(distanceTracker)))
+	assert.Equal(t, "", distanceTracker.PendingAction)
+
+	// arriving, then immediately leaving again replaces the pending open with a pending close
+	distanceTracker.CurrentLocation.Lat = distanceGeofence.Center.Lat
+	assert.Equal(t, "", distanceTracker.confirmAction(distanceGeofence.getEventChangeAction(distanceTracker)))
+	assert.Equal(t, ActionOpen, distanceTracker.PendingAction)
+	distanceTracker.CurrentLocation.Lat = distanceGeofence.Center.Lat + 10
+	assert.Equal(t, "", distanceTracker.confirmAction(distanceGeofence.getEventChangeAction(distanceTracker)))
+	assert.Equal(t, ActionClose, distanceTracker.PendingAction)
+
+	// moving back inside the close geofence without crossing another boundary discards the pending action
+	distanceTracker.CurDistance = 0
+	assert.Equal(t, "", distanceTracker.confirmAction(""))
+	assert.Equal(t, "", distanceTracker.PendingAction)
+
+	// seconds based confirmation
+	distanceGarageDoor.Confirmation = ConfirmationSettings{Seconds: 5}
+	distanceTracker.CurDistance = 100
+	distanceTracker.CurrentLocation.Lat = distanceGeofence.Center.Lat
+	assert.Equal(t, "", distanceTracker.confirmAction(distanceGeofence.getEventChangeAction(distanceTracker)))
+	distanceTracker.PendingActionSince = time.Now().Add(-10 * time.Second)
+	assert.Equal(t, ActionOpen, distanceTracker.confirmAction(distanceGeofence.getEventChangeAction(distanceTracker)))
+}
+
+func Test_CheckCircularGeofence_Occupancy(t *testing.T) {
+	mockGdo := &mocks.GDO{}
+	distanceTracker.GarageDoor.Opener = mockGdo
+	defer mockGdo.AssertExpectations(t)
+
+	distanceGarageDoor.Occupancy = OccupancySettings{CloseWhenEmpty: true, OpenWhenEmpty: true}
+	defer func() { distanceGarageDoor.Occupancy = OccupancySettings{} }() // restore settings
+
+	// second tracker is still parked at home
+	otherTracker := distanceGarageDoor.Trackers[1]
+	otherTracker.CurrentLocation = distanceGeofence.Center
+	otherTracker.CurDistance = 0
+	defer func() { otherTracker.CurrentLocation = Point{} }()
+
+	// leaving while other tracker is home should not close
+	distanceTracker.CurDistance = 0
+	distanceTracker.CurrentLocation.Lat = distanceGeofence.Center.Lat + 10
+	distanceTracker.CurrentLocation.Lng = distanceGeofence.Center.Lng
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+
+	// arriving while other tracker is home should not open
+	distanceTracker.CurrentLocation.Lat = distanceGeofence.Center.Lat
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+
+	// once the other tracker is gone, leaving should close
+	otherTracker.CurDistance = 100
+	mockGdo.EXPECT().SetGarageDoor(ActionClose).Return(nil)
+	distanceTracker.CurrentLocation.Lat = distanceGeofence.Center.Lat + 10
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+}
+
+func Test_loadGeoJSONFile(t *testing.T) {
+	p := &PolygonGeofence{}
+	err := p.parseSettings(map[string]interface{}{"geojson_file": filepath.Join("..", "..", "resources", "polygon_map.geojson")})
+	assert.Nil(t, err)
+	assert.Equal(t, polygonGeofence.Open, p.Open)
+	assert.Equal(t, polygonGeofence.Close, p.Close)
+	assert.Equal(t, polygonGeofence.Restricted, p.Restricted)
+
+	dir := t.TempDir()
+	writeGeoJSON := func(geometry string) string {
+		file := filepath.Join(dir, "geofence.geojson")
+		content := `{"type":"FeatureCollection","features":[{"type":"Feature","properties":{"role":"open"},"geometry":` + geometry + `}]}`
+		assert.Nil(t, os.WriteFile(file, []byte(content), 0644))
+		return file
+	}
+
+	// wrong geometry type
+	p = &PolygonGeofence{GeoJSONFile: writeGeoJSON(`{"type":"LineString","coordinates":[[-123.79,46.19],[-123.80,46.19]]}`)}
+	assert.ErrorContains(t, loadGeoJSONFile(p), "Polygon or MultiPolygon")
+
+	// lat, lng order instead of lng, lat
+	p = &PolygonGeofence{GeoJSONFile: writeGeoJSON(`{"type":"Polygon","coordinates":[[[46.19,-123.79],[46.19,-123.80],[46.18,-123.80],[46.19,-123.79]]]}`)}
+	assert.ErrorContains(t, loadGeoJSONFile(p), "[longitude, latitude] order")
+
+	// single polygon in a MultiPolygon
+	p = &PolygonGeofence{GeoJSONFile: writeGeoJSON(`{"type":"MultiPolygon","coordinates":[[[[-123.79,46.19],[-123.80,46.19],[-123.80,46.18],[-123.79,46.19]]]]}`)}
+	assert.Nil(t, loadGeoJSONFile(p))
+	assert.Equal(t, 1, len(p.Open))
+	assert.Equal(t, 4, len(p.Open[0].Outer))
+
+	// multiple polygons with holes
+	p = &PolygonGeofence{GeoJSONFile: writeGeoJSON(`{"type":"MultiPolygon","coordinates":[
+		[[[-123.79,46.19],[-123.80,46.19],[-123.80,46.18],[-123.79,46.18],[-123.79,46.19]],[[-123.794,46.186],[-123.796,46.186],[-123.796,46.184],[-123.794,46.184],[-123.794,46.186]]],
+		[[[-123.70,46.10],[-123.71,46.10],[-123.71,46.09],[-123.70,46.10]]]
+	]}`)}
+	assert.Nil(t, loadGeoJSONFile(p))
+	assert.Equal(t, 2, len(p.Open))
+	assert.Equal(t, 1, len(p.Open[0].Holes))
+}
+
+func Test_PolygonsHolesAndLists(t *testing.T) {
+	var settings PolygonGeofence
+	err := yaml.Unmarshal([]byte(`
+open:
+  - outer:
+      - {lat: 46.19, lng: -123.79}
+      - {lat: 46.19, lng: -123.80}
+      - {lat: 46.18, lng: -123.80}
+      - {lat: 46.18, lng: -123.79}
+    holes:
+      - - {lat: 46.186, lng: -123.794}
+        - {lat: 46.186, lng: -123.796}
+        - {lat: 46.184, lng: -123.796}
+        - {lat: 46.184, lng: -123.794}
+restricted:
+  - outer: [{lat: 46.10, lng: -123.70}, {lat: 46.10, lng: -123.71}, {lat: 46.09, lng: -123.71}, {lat: 46.09, lng: -123.70}]
+  - outer: [{lat: 46.20, lng: -123.70}, {lat: 46.20, lng: -123.71}, {lat: 46.19, lng: -123.71}, {lat: 46.19, lng: -123.70}]
+close:
+  - {lat: 46.19, lng: -123.79}
+  - {lat: 46.19, lng: -123.80}
+  - {lat: 46.18, lng: -123.80}
+`), &settings)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(settings.Open))
+	assert.Equal(t, 2, len(settings.Restricted))
+	assert.Equal(t, 1, len(settings.Close)) // legacy list of points is a single polygon
+	assert.Equal(t, 3, len(settings.Close[0].Outer))
+
+	assert.Equal(t, true, settings.Open.contains(Point{Lat: 46.182, Lng: -123.798}))
+	assert.Equal(t, false, settings.Open.contains(Point{Lat: 46.185, Lng: -123.795})) // inside hole
+	assert.Equal(t, true, settings.Restricted.contains(Point{Lat: 46.195, Lng: -123.705}))
+	assert.Equal(t, true, settings.Restricted.contains(Point{Lat: 46.095, Lng: -123.705}))
+	assert.Equal(t, false, settings.Restricted.contains(Point{Lat: 46.15, Lng: -123.705}))
+
+	// single polygon without holes is marshalled back to a list of points
+	out, err := yaml.Marshal(settings.Close)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(out), "outer")
+
+	// kml inner boundaries are parsed as holes, and multiple placemarks with the same name are combined
+	kmlFile := filepath.Join(t.TempDir(), "geofence.kml")
+	assert.Nil(t, os.WriteFile(kmlFile, []byte(`<kml><Document>
+		<Placemark><name>open</name><Polygon>
+			<outerBoundaryIs><LinearRing><coordinates>-123.79,46.19 -123.80,46.19 -123.80,46.18 -123.79,46.18</coordinates></LinearRing></outerBoundaryIs>
+			<innerBoundaryIs><LinearRing><coordinates>-123.794,46.186 -123.796,46.186 -123.796,46.184 -123.794,46.184</coordinates></LinearRing></innerBoundaryIs>
+		</Polygon></Placemark>
+		<Placemark><name>restricted</name><Polygon><outerBoundaryIs><LinearRing><coordinates>-123.70,46.10 -123.71,46.10 -123.71,46.09</coordinates></LinearRing></outerBoundaryIs></Polygon></Placemark>
+		<Placemark><name>restricted</name><Polygon><outerBoundaryIs><LinearRing><coordinates>-123.70,46.20 -123.71,46.20 -123.71,46.19</coordinates></LinearRing></outerBoundaryIs></Polygon></Placemark>
+	</Document></kml>`), 0644))
+	settings = PolygonGeofence{KMLFile: kmlFile}
+	assert.Nil(t, loadKMLFile(&settings))
+	assert.Equal(t, 1, len(settings.Open[0].Holes))
+	assert.Equal(t, 2, len(settings.Restricted))
+	assert.Equal(t, false, settings.Open.contains(Point{Lat: 46.185, Lng: -123.795}))
+}
+
+func Test_HeadingRange(t *testing.T) {
+	h := HeadingRange{From: 300, To: 30}
+	assert.Equal(t, true, h.contains(0))
+	assert.Equal(t, true, h.contains(310))
+	assert.Equal(t, true, h.contains(-20))
+	assert.Equal(t, false, h.contains(180))
+
+	h = HeadingRange{From: 90, To: 180}
+	assert.Equal(t, true, h.contains(135))
+	assert.Equal(t, false, h.contains(270))
+
+	assert.InDelta(t, 0, bearing(Point{Lat: 46.0, Lng: -123.0}, Point{Lat: 46.1, Lng: -123.0}), 0.01)
+	assert.InDelta(t, 90, bearing(Point{Lat: 0.0001, Lng: -123.0}, Point{Lat: 0.0001, Lng: -122.9}), 0.01)
+	assert.InDelta(t, 180, bearing(Point{Lat: 46.1, Lng: -123.0}, Point{Lat: 46.0, Lng: -123.0}), 0.01)
+}
+
+func Test_getEventChangeAction_CircularOpenHeading(t *testing.T) {
+	distanceGeofence.OpenHeading = HeadingRange{From: 135, To: 225} // only open when heading south
+	defer func() { distanceGeofence.OpenHeading = HeadingRange{} }()
+	defer func() { distanceTracker.HasCourse = false }()
+
+	// approaching from the south, heading north, should not open
+	distanceTracker.CurrentLocation = Point{Lat: distanceGeofence.Center.Lat - 1, Lng: distanceGeofence.Center.Lng}
+	distanceTracker.CurDistance = 100
+	distanceTracker.HasCourse = false
+	assert.Equal(t, true, distanceTracker.ApplyFix(Fix{Point: distanceGeofence.Center}))
+	assert.InDelta(t, 0, distanceTracker.Course, 0.01) // heading derived from consecutive fixes
+	assert.Equal(t, "", distanceGeofence.getEventChangeAction(distanceTracker))
+
+	// approaching from the north, heading south, should open
+	distanceTracker.CurrentLocation = Point{Lat: distanceGeofence.Center.Lat + 1, Lng: distanceGeofence.Center.Lng}
+	distanceTracker.CurDistance = 100
+	assert.Equal(t, true, distanceTracker.ApplyFix(Fix{Point: distanceGeofence.Center}))
+	assert.Equal(t, ActionOpen, distanceGeofence.getEventChangeAction(distanceTracker))
+
+	// reported course takes precedence over derived heading
+	distanceTracker.CurrentLocation = Point{Lat: distanceGeofence.Center.Lat + 1, Lng: distanceGeofence.Center.Lng}
+	distanceTracker.CurDistance = 100
+	assert.Equal(t, true, distanceTracker.ApplyFix(Fix{Point: distanceGeofence.Center, Course: 10, HasCourse: true}))
+	assert.Equal(t, "", distanceGeofence.getEventChangeAction(distanceTracker))
+}
+
 // runs CheckGeofence and waits for the internal goroutine to complete, signified by the release of oplock,
 // with 100 ms timeout
 func checkGeofenceWrapper(tracker *Tracker) bool {
 	CheckGeofence(tracker)
 	// wait for oplock to be released with a 100 ms timeout
 	for i := 0; i < 10; i++ {
-		if !tracker.GarageDoor.OpLock {
+		if !tracker.GarageDoor.OpLock.Load() {
 			return true
 		}
 		time.Sleep(10 * time.Millisecond)
 	}
 	return false
 }
+
+// applies a payload published to the tracker's geofence topic, as processLocationUpdates would
+func applyStatePayload(tracker *Tracker, payload string) error {
+	state, err := tracker.ParseStatePayload([]byte(payload))
+	if err != nil {
+		return err
+	}
+	tracker.ApplyFix(Fix{State: state, HasState: true})
+	return nil
+}
+
+func Test_getEventChangeAction_CircularOpenETA(t *testing.T) {
+	distanceGeofence.OpenETA = 15
+	defer func() { distanceGeofence.OpenETA = 0 }()
+	defer func() {
+		distanceTracker.HasVelocity, distanceTracker.WithinOpenETA, distanceTracker.OpenedByETA = false, false, false
+	}()
+
+	// returns a point north of the geofence center, the given distance in km beyond the open geofence
+	beyondOpenGeo := func(km float64) Point {
+		return Point{Lat: distanceGeofence.Center.Lat + (distanceGeofence.OpenDistance+km)/111.195, Lng: distanceGeofence.Center.Lng}
+	}
+
+	// approaching at 36 km/h (10 m/s), 1 km from the open geofence, should not open
+	distanceTracker.CurrentLocation = beyondOpenGeo(2)
+	distanceTracker.CurDistance = 100
+	assert.Equal(t, true, distanceTracker.ApplyFix(Fix{Point: beyondOpenGeo(1), Velocity: 36, HasVelocity: true}))
+	assert.Equal(t, "", distanceGeofence.getEventChangeAction(distanceTracker))
+
+	// 100 m from the open geofence, estimated arrival in 10 seconds, should open
+	assert.Equal(t, true, distanceTracker.ApplyFix(Fix{Point: beyondOpenGeo(.1), Velocity: 36, HasVelocity: true}))
+	assert.Equal(t, ActionOpen, distanceGeofence.getEventChangeAction(distanceTracker))
+	distanceTracker.OpenedByETA = true // as if executeAction executed the open
+
+	// still approaching, should not open again
+	assert.Equal(t, true, distanceTracker.ApplyFix(Fix{Point: beyondOpenGeo(.05), Velocity: 36, HasVelocity: true}))
+	assert.Equal(t, "", distanceGeofence.getEventChangeAction(distanceTracker))
+
+	// entering the open geofence after opening early should not open again
+	assert.Equal(t, true, distanceTracker.ApplyFix(Fix{Point: distanceGeofence.Center, Velocity: 36, HasVelocity: true}))
+	assert.Equal(t, "", distanceGeofence.getEventChangeAction(distanceTracker))
+	assert.Equal(t, false, distanceTracker.WithinOpenETA)
+
+	// moving away quickly should close, not open
+	distanceTracker.CurDistance = 0
+	assert.Equal(t, true, distanceTracker.ApplyFix(Fix{Point: beyondOpenGeo(.05), Velocity: 100, HasVelocity: true}))
+	assert.Equal(t, ActionClose, distanceGeofence.getEventChangeAction(distanceTracker))
+	assert.Equal(t, true, distanceTracker.ApplyFix(Fix{Point: beyondOpenGeo(.1), Velocity: 100, HasVelocity: true}))
+	assert.Equal(t, "", distanceGeofence.getEventChangeAction(distanceTracker))
+
+	// approaching slowly, should only open when entering the open geofence
+	assert.Equal(t, true, distanceTracker.ApplyFix(Fix{Point: beyondOpenGeo(.05), Velocity: 5, HasVelocity: true}))
+	assert.Equal(t, "", distanceGeofence.getEventChangeAction(distanceTracker))
+	assert.Equal(t, true, distanceTracker.ApplyFix(Fix{Point: distanceGeofence.Center, Velocity: 5, HasVelocity: true}))
+	assert.Equal(t, ActionOpen, distanceGeofence.getEventChangeAction(distanceTracker))
+}
+
+func Test_ApplyFix_DerivedSpeed(t *testing.T) {
+	tracker := &Tracker{ID: "speed"}
+	start := time.Now().Add(-time.Minute)
+	p1 := Point{Lat: 46.19, Lng: -123.79}
+	p2 := Point{Lat: 46.19 + 1/111.195, Lng: -123.79} // ~1 km north
+
+	assert.Equal(t, true, tracker.ApplyFix(Fix{Point: p1, Timestamp: start}))
+	assert.Equal(t, false, tracker.HasVelocity)
+
+	// 1 km in 60 seconds is 60 km/h
+	assert.Equal(t, true, tracker.ApplyFix(Fix{Point: p2, Timestamp: start.Add(time.Minute)}))
+	assert.Equal(t, true, tracker.HasVelocity)
+	assert.InDelta(t, 60, tracker.Velocity, 0.5)
+
+	// reported velocity takes precedence
+	assert.Equal(t, true, tracker.ApplyFix(Fix{Point: p1, Timestamp: start.Add(2 * time.Minute), Velocity: 20, HasVelocity: true}))
+	assert.Equal(t, float64(20), tracker.Velocity)
+
+	// speed is not derived when a speed topic is defined
+	tracker.SpeedTopic = "speed/topic"
+	assert.Equal(t, true, tracker.ApplyFix(Fix{Point: p2, Timestamp: start.Add(3 * time.Minute)}))
+	assert.Equal(t, float64(20), tracker.Velocity)
+}
+
+func Test_ApplyFix_DerivedFromCompleteFixes(t *testing.T) {
+	tracker := &Tracker{ID: "split", LatTopic: "lat/topic", LngTopic: "lng/topic"}
+	start := time.Now().Add(-time.Minute)
+	p1 := Point{Lat: 46.19, Lng: -123.79}
+	p2 := Point{Lat: 46.19 + 1/111.195, Lng: -123.79 + 1/77.0} // ~1 km north and east
+
+	assert.Equal(t, true, tracker.ApplyFix(Fix{Point: p1, Timestamp: start}))
+
+	// a lone lat or lng only moves the location along one axis, so neither speed nor heading is derived
+	assert.Equal(t, true, tracker.ApplyFix(Fix{Point: Point{Lat: p2.Lat}, Timestamp: start.Add(30 * time.Second)}))
+	assert.Equal(t, true, tracker.ApplyFix(Fix{Point: Point{Lng: p2.Lng}, Timestamp: start.Add(time.Minute)}))
+	assert.Equal(t, false, tracker.HasVelocity)
+	assert.Equal(t, false, tracker.HasCourse)
+
+	// nor are they derived from a location that was partly updated
+	assert.Equal(t, true, tracker.ApplyFix(Fix{Point: p1, Timestamp: start.Add(2 * time.Minute)}))
+	assert.Equal(t, false, tracker.HasVelocity)
+	assert.Equal(t, false, tracker.HasCourse)
+
+	// consecutive complete fixes, e.g. paired within the pairing window, derive both
+	assert.Equal(t, true, tracker.ApplyFix(Fix{Point: p2, Timestamp: start.Add(3 * time.Minute)}))
+	assert.Equal(t, true, tracker.HasVelocity)
+	assert.Equal(t, true, tracker.HasCourse)
+	assert.InDelta(t, 45, tracker.Course, 5)
+}
+
+func Test_Polygons_distanceTo(t *testing.T) {
+	square := Polygons{{Outer: []Point{{Lat: 0, Lng: 0}, {Lat: 0, Lng: 0.01}, {Lat: 0.01, Lng: 0.01}, {Lat: 0.01, Lng: 0}}}}
+	// ~1.112 km south of the southern edge
+	assert.InDelta(t, 1.112, square.distanceTo(Point{Lat: -0.01, Lng: 0.005}), 0.01)
+	// diagonal from the southwest corner
+	assert.InDelta(t, distance(Point{Lat: -0.01, Lng: -0.01}, Point{}), square.distanceTo(Point{Lat: -0.01, Lng: -0.01}), 0.01)
+}
+
+func Test_StateGeofence(t *testing.T) {
+	g, err := newGeofence(map[string]interface{}{
+		"type": "state",
+		"settings": map[string]interface{}{
+			"json_path": "attributes.zones.0",
+			"regex":     true,
+			"close_trigger": map[string]interface{}{
+				"from": "home",
+				"to":   []interface{}{"not_home", "away.*"},
+			},
+			"open_trigger": map[string]interface{}{
+				"to": "home",
+			},
+		},
+	})
+	assert.Nil(t, err)
+	stateGeofence, ok := g.(*StateGeofence)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, StateValues{"home"}, stateGeofence.Close.From)
+
+	tracker := &Tracker{ID: "state", GarageDoor: &GarageDoor{Geofence: g}}
+	applyState := func(payload string) string {
+		assert.Nil(t, applyStatePayload(tracker, payload))
+		return g.getEventChangeAction(tracker)
+	}
+
+	// first state received is not a change, even though open_trigger has no `from`
+	assert.Equal(t, "", applyState(`{"attributes": {"zones": ["home"]}}`))
+	assert.Equal(t, "home", tracker.CurGeofence)
+	// regex match on the `to` state
+	assert.Equal(t, ActionClose, applyState(`{"attributes": {"zones": ["away_work"]}}`))
+	// repeated state is not a change
+	assert.Equal(t, "", applyState(`{"attributes": {"zones": ["away_work"]}}`))
+	// open from any state
+	assert.Equal(t, ActionOpen, applyState(`{"attributes": {"zones": ["home"]}}`))
+	// regex must match the entire state
+	assert.Equal(t, "", applyState(`{"attributes": {"zones": ["not_home_yet"]}}`))
+
+	// invalid json path leaves state unchanged
+	assert.NotNil(t, applyStatePayload(tracker, `{"attributes": {}}`))
+	assert.NotNil(t, applyStatePayload(tracker, `home`))
+	assert.Equal(t, "not_home_yet", tracker.CurGeofence)
+
+	// invalid regex fails to parse
+	_, err = newGeofence(map[string]interface{}{
+		"type": "state",
+		"settings": map[string]interface{}{
+			"regex":         true,
+			"close_trigger": map[string]interface{}{"to": "("},
+		},
+	})
+	assert.NotNil(t, err)
+
+	// the teslamate preset ignores triggers without a `from`
+	g, err = newGeofence(map[string]interface{}{
+		"type": "teslamate",
+		"settings": map[string]interface{}{
+			"close_trigger": map[string]interface{}{"from": "home", "to": "not_home"},
+			"open_trigger":  map[string]interface{}{"to": "home"},
+		},
+	})
+	assert.Nil(t, err)
+	tracker = &Tracker{ID: "teslamate", GarageDoor: &GarageDoor{Geofence: g}, PrevGeofence: "home", CurGeofence: "not_home"}
+	assert.Equal(t, ActionClose, g.getEventChangeAction(tracker))
+	// heading, speed, and recheck fixes don't trigger the same state change again
+	tracker.ApplyFix(Fix{Course: 90, HasCourse: true})
+	assert.Equal(t, "", g.getEventChangeAction(tracker))
+	tracker.PrevGeofence = "home"
+	tracker.ApplyFix(Fix{Velocity: 30, HasVelocity: true})
+	assert.Equal(t, "", g.getEventChangeAction(tracker))
+	tracker.PrevGeofence = "home"
+	assert.Equal(t, true, tracker.ApplyFix(Fix{Recheck: true}))
+	assert.Equal(t, "", g.getEventChangeAction(tracker))
+	tracker.PrevGeofence, tracker.CurGeofence = "not_home", "home"
+	assert.Equal(t, "", g.getEventChangeAction(tracker))
+	assert.Equal(t, false, g.isOnActionSide(tracker, ActionOpen))
+}
+
+func Test_CompositeGeofence(t *testing.T) {
+	circularConfig := map[string]interface{}{
+		"type": "circular",
+		"settings": map[string]interface{}{
+			"center":         map[string]interface{}{"lat": distanceGeofence.Center.Lat, "lng": distanceGeofence.Center.Lng},
+			"close_distance": distanceGeofence.CloseDistance,
+			"open_distance":  distanceGeofence.OpenDistance,
+		},
+	}
+	teslamateConfig := map[string]interface{}{
+		"type": "teslamate",
+		"settings": map[string]interface{}{
+			"close_trigger": map[string]interface{}{"from": "home", "to": "not_home"},
+			"open_trigger":  map[string]interface{}{"from": "not_home", "to": "home"},
+		},
+	}
+	far := Point{Lat: distanceGeofence.Center.Lat + 1, Lng: distanceGeofence.Center.Lng}
+
+	g, err := newGeofence(map[string]interface{}{
+		"type":     "composite",
+		"settings": map[string]interface{}{"geofences": []interface{}{circularConfig, teslamateConfig}},
+	})
+	assert.Nil(t, err)
+	composite := g.(*CompositeGeofence)
+	assert.Equal(t, OperatorAnd, composite.Operator)
+
+	// and: entering the circular open geofence doesn't open until teslamate agrees
+	tracker := &Tracker{ID: "composite", GarageDoor: &GarageDoor{Geofence: g}, CurDistance: 100, CurGeofence: "not_home"}
+	tracker.CurrentLocation = distanceGeofence.Center
+	assert.Equal(t, "", g.getEventChangeAction(tracker))
+	assert.Nil(t, applyStatePayload(tracker, "home"))
+	assert.Equal(t, ActionOpen, g.getEventChangeAction(tracker))
+	assert.Equal(t, true, g.isHome(tracker))
+
+	// and: teslamate leaving home first, then circular close geofence
+	assert.Nil(t, applyStatePayload(tracker, "not_home"))
+	assert.Equal(t, "", g.getEventChangeAction(tracker))
+	tracker.CurrentLocation = far
+	assert.Equal(t, ActionClose, g.getEventChangeAction(tracker))
+	assert.Equal(t, false, g.isHome(tracker))
+	// the state change is only acted on once, not again with each subsequent location update
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, true, tracker.ApplyFix(Fix{Point: Point{Lat: far.Lat + float64(i)/1000, Lng: far.Lng}}))
+		assert.Equal(t, "", g.getEventChangeAction(tracker))
+	}
+
+	// or: either geofence triggers the action
+	g, err = newGeofence(map[string]interface{}{
+		"type":     "composite",
+		"settings": map[string]interface{}{"operator": "OR", "geofences": []interface{}{circularConfig, teslamateConfig}},
+	})
+	assert.Nil(t, err)
+	tracker = &Tracker{ID: "composite", GarageDoor: &GarageDoor{Geofence: g}, CurDistance: 100, CurGeofence: "not_home"}
+	tracker.CurrentLocation = distanceGeofence.Center
+	assert.Equal(t, ActionOpen, g.getEventChangeAction(tracker))
+	assert.Nil(t, applyStatePayload(tracker, "home"))
+	assert.Equal(t, ActionOpen, g.getEventChangeAction(tracker))
+	assert.Equal(t, true, g.isHome(tracker))
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, true, tracker.ApplyFix(Fix{Point: Point{Lat: distanceGeofence.Center.Lat + float64(i)/100000, Lng: distanceGeofence.Center.Lng}}))
+		assert.Equal(t, "", g.getEventChangeAction(tracker))
+	}
+
+	// startup: a state alone doesn't seed the circular geofence, so the tracker isn't initialized until it reports a location
+	g, err = newGeofence(map[string]interface{}{
+		"type":     "composite",
+		"settings": map[string]interface{}{"geofences": []interface{}{circularConfig, teslamateConfig}},
+	})
+	assert.Nil(t, err)
+	tracker = &Tracker{ID: "composite", GarageDoor: &GarageDoor{Geofence: g}}
+	assert.Nil(t, applyStatePayload(tracker, "home"))
+	tracker.initialize()
+	assert.Equal(t, false, tracker.initialized)
+	assert.Equal(t, true, tracker.ApplyFix(Fix{Point: far}))
+	tracker.initialize()
+	assert.Equal(t, true, tracker.initialized)
+	assert.Greater(t, tracker.CurDistance, distanceGeofence.CloseDistance)
+	assert.Equal(t, true, tracker.ApplyFix(Fix{Point: Point{Lat: far.Lat + .001, Lng: far.Lng}}))
+	assert.Equal(t, "", g.getEventChangeAction(tracker))
+
+	// invalid configs
+	for _, settings := range []map[string]interface{}{
+		{"operator": "xor", "geofences": []interface{}{circularConfig, teslamateConfig}},
+		{"geofences": []interface{}{circularConfig}},
+		{"geofences": []interface{}{circularConfig, circularConfig}},
+		{"geofences": []interface{}{circularConfig, map[string]interface{}{"type": "composite"}}},
+	} {
+		_, err = newGeofence(map[string]interface{}{"type": "composite", "settings": settings})
+		assert.NotNil(t, err)
+	}
+}
+
+func Test_ScheduleSettings(t *testing.T) {
+	var s ScheduleSettings
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+timezone: America/New_York
+open:
+  - days: [weekdays]
+    from: "06:00"
+    to: "22:00"
+  - days: [Saturday]
+    from: "22:00"
+    to: "02:00"
+`), &s))
+	assert.Nil(t, s.parse())
+
+	newYork, _ := time.LoadLocation("America/New_York")
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, newYork) // 2024-01-01 is a monday
+	}
+
+	allowed, _ := s.allows(ActionOpen, at(1, 12, 0))
+	assert.Equal(t, true, allowed)
+	allowed, reason := s.allows(ActionOpen, at(1, 3, 0))
+	assert.Equal(t, false, allowed)
+	assert.Contains(t, reason, "Mon 03:00")
+	allowed, _ = s.allows(ActionOpen, at(1, 22, 0)) // end is exclusive
+	assert.Equal(t, false, allowed)
+	// overnight window starting saturday
+	allowed, _ = s.allows(ActionOpen, at(6, 23, 0))
+	assert.Equal(t, true, allowed)
+	allowed, _ = s.allows(ActionOpen, at(7, 1, 30))
+	assert.Equal(t, true, allowed)
+	allowed, _ = s.allows(ActionOpen, at(7, 12, 0))
+	assert.Equal(t, false, allowed)
+	// times are converted to the schedule's timezone
+	allowed, _ = s.allows(ActionOpen, at(1, 12, 0).UTC())
+	assert.Equal(t, true, allowed)
+	// no close windows means close is always allowed
+	allowed, _ = s.allows(ActionClose, at(1, 3, 0))
+	assert.Equal(t, true, allowed)
+
+	for _, invalid := range []ScheduleSettings{
+		{Timezone: "Not/AZone"},
+		{Open: []ScheduleWindow{{From: "6am", To: "22:00"}}},
+		{Close: []ScheduleWindow{{Days: []string{"someday"}, From: "06:00", To: "22:00"}}},
+		{Close: []ScheduleWindow{{Days: []string{"monxyz"}, From: "06:00", To: "22:00"}}},
+		{Close: []ScheduleWindow{{Days: []string{"sunshine"}, From: "06:00", To: "22:00"}}},
+	} {
+		assert.NotNil(t, invalid.parse())
+	}
+}
+
+func Test_CheckCircularGeofence_Schedule(t *testing.T) {
+	mockGdo := &mocks.GDO{}
+	distanceTracker.GarageDoor.Opener = mockGdo
+	defer mockGdo.AssertExpectations(t)
+	defer func() { distanceGarageDoor.Schedule = ScheduleSettings{} }() // restore settings
+	now = func() time.Time { return time.Date(2024, 1, 3, 12, 0, 0, 0, time.Local) }
+	defer func() { now = time.Now }()
+
+	// only allow closing during a window that has already ended, should not close
+	distanceGarageDoor.Schedule = ScheduleSettings{Close: []ScheduleWindow{{From: "10:00", To: "11:00"}}}
+	assert.Nil(t, distanceGarageDoor.Schedule.parse())
+	distanceTracker.CurDistance = 0
+	distanceTracker.CurrentLocation.Lat = distanceGeofence.Center.Lat + 10
+	distanceTracker.CurrentLocation.Lng = distanceGeofence.Center.Lng
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+
+	// within the close window, should close
+	distanceGarageDoor.Schedule.Close[0].From = "11:00"
+	distanceGarageDoor.Schedule.Close[0].To = "13:00"
+	assert.Nil(t, distanceGarageDoor.Schedule.parse())
+	mockGdo.EXPECT().SetGarageDoor(ActionClose).Return(nil)
+	distanceTracker.CurDistance = 0
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+}
+
+func Test_CalendarSettings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "calendar.ics")
+	writeCalendar := func(events string) {
+		assert.Nil(t, os.WriteFile(file, []byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"+events+"END:VCALENDAR\r\n"), 0644))
+	}
+	writeCalendar("BEGIN:VEVENT\r\n" +
+		"SUMMARY:Family Vacation\r\n" +
+		"DTSTART;VALUE=DATE:20240105\r\n" +
+		"DTEND;VALUE=DATE:20240108\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Contractor\\, kitchen\r\n" +
+		"DTSTART;TZID=America/New_York:20240102T090000\r\n" +
+		"DURATION:PT8H\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Dentist\r\n" +
+		"DTSTART:20240103T150000Z\r\n" +
+		"DTEND:20240103T160000Z\r\n" +
+		"END:VEVENT\r\n")
+
+	newYork, _ := time.LoadLocation("America/New_York")
+	s := ScheduleSettings{
+		Timezone: "America/New_York",
+		Calendar: CalendarSettings{
+			File: file,
+			Events: []CalendarEventRule{
+				{Summary: "vacation"},
+				{Summary: "CONTRACTOR", Actions: []string{ActionClose}},
+			},
+		},
+	}
+	assert.Nil(t, s.parse())
+	at := func(day, hour int) time.Time { return time.Date(2024, 1, day, hour, 0, 0, 0, newYork) }
+
+	// all-day vacation event pauses both actions through the end date (exclusive)
+	allowed, reason := s.allows(ActionOpen, at(6, 12))
+	assert.Equal(t, false, allowed)
+	assert.Contains(t, reason, "Family Vacation")
+	allowed, _ = s.allows(ActionClose, at(7, 23))
+	assert.Equal(t, false, allowed)
+	allowed, _ = s.allows(ActionOpen, at(8, 0))
+	assert.Equal(t, true, allowed)
+
+	// contractor event only restricts closing, with escaped summary and duration
+	allowed, _ = s.allows(ActionClose, at(2, 16))
+	assert.Equal(t, false, allowed)
+	allowed, _ = s.allows(ActionOpen, at(2, 16))
+	assert.Equal(t, true, allowed)
+	allowed, _ = s.allows(ActionClose, at(2, 17))
+	assert.Equal(t, true, allowed)
+
+	// unmatched events don't suppress actions
+	allowed, _ = s.allows(ActionOpen, time.Date(2024, 1, 3, 15, 30, 0, 0, time.UTC))
+	assert.Equal(t, true, allowed)
+
+	// changes to the file are picked up
+	writeCalendar("BEGIN:VEVENT\r\n" +
+		"SUMMARY:Vacation\r\n" +
+		"DTSTART:20240110T000000\r\n" +
+		"DTEND:20240111T000000\r\n" +
+		"END:VEVENT\r\n")
+	allowed, _ = s.allows(ActionOpen, at(6, 12))
+	assert.Equal(t, true, allowed)
+	allowed, _ = s.allows(ActionOpen, at(10, 12))
+	assert.Equal(t, false, allowed)
+
+	// missing files and events without rules fail to parse
+	assert.NotNil(t, (&ScheduleSettings{Calendar: CalendarSettings{File: file + ".missing", Events: s.Calendar.Events}}).parse())
+	assert.NotNil(t, (&ScheduleSettings{Calendar: CalendarSettings{File: file}}).parse())
+}
+
+func Test_CalendarSettings_Recurring(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "calendar.ics")
+	assert.Nil(t, os.WriteFile(file, []byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"+
+		"BEGIN:VEVENT\r\n"+
+		"SUMMARY:Cleaner\r\n"+
+		"DTSTART;TZID=America/New_York:20240102T100000\r\n"+
+		"DTEND;TZID=America/New_York:20240102T120000\r\n"+
+		"RRULE:FREQ=WEEKLY;BYDAY=TU,TH;UNTIL=20240201T000000Z\r\n"+
+		"EXDATE;TZID=America/New_York:20240111T100000\r\n"+
+		"END:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\n"+
+		"SUMMARY:Vacation\r\n"+
+		"DTSTART:20240301T080000\r\n"+
+		"DURATION:PT1H\r\n"+
+		"RRULE:FREQ=DAILY;INTERVAL=2;COUNT=3\r\n"+
+		"END:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\n"+
+		"SUMMARY:Monthly vacation\r\n"+
+		"DTSTART:20240401T080000\r\n"+
+		"DTEND:20240401T090000\r\n"+
+		"RRULE:FREQ=MONTHLY\r\n"+
+		"END:VEVENT\r\n"+
+		"END:VCALENDAR\r\n"), 0644))
+
+	newYork, _ := time.LoadLocation("America/New_York")
+	s := ScheduleSettings{
+		Timezone: "America/New_York",
+		Calendar: CalendarSettings{
+			File: file,
+			Events: []CalendarEventRule{
+				{Summary: "vacation"},
+				{Summary: "cleaner", Actions: []string{ActionClose}},
+			},
+		},
+	}
+	assert.Nil(t, s.parse())
+	allowed := func(action string, month time.Month, day, hour int) bool {
+		allowed, _ := s.allows(action, time.Date(2024, month, day, hour, 30, 0, 0, newYork))
+		return allowed
+	}
+
+	// weekly event recurs on each of its days until its end, except excluded dates
+	assert.Equal(t, false, allowed(ActionClose, time.January, 9, 11))
+	assert.Equal(t, true, allowed(ActionOpen, time.January, 9, 11))
+	assert.Equal(t, true, allowed(ActionClose, time.January, 10, 11))
+	assert.Equal(t, true, allowed(ActionClose, time.January, 11, 11))
+	assert.Equal(t, false, allowed(ActionClose, time.January, 18, 11))
+	assert.Equal(t, true, allowed(ActionClose, time.January, 18, 12))
+	assert.Equal(t, true, allowed(ActionClose, time.February, 6, 11))
+
+	// daily event recurs every other day for its count of occurrences
+	assert.Equal(t, false, allowed(ActionOpen, time.March, 3, 8))
+	assert.Equal(t, true, allowed(ActionOpen, time.March, 4, 8))
+	assert.Equal(t, false, allowed(ActionOpen, time.March, 5, 8))
+	assert.Equal(t, true, allowed(ActionOpen, time.March, 7, 8))
+
+	// unsupported rules are only considered for their first occurrence
+	assert.Equal(t, false, allowed(ActionOpen, time.April, 1, 8))
+	assert.Equal(t, true, allowed(ActionOpen, time.May, 1, 8))
+
+	// events in progress are reported with the actions they suppress and the end of the current occurrence
+	assert.Equal(t, []CalendarStatus{{Event: "Cleaner", Actions: []string{ActionClose}, Until: time.Date(2024, 1, 9, 12, 0, 0, 0, newYork)}},
+		s.calendarSuppressions(time.Date(2024, 1, 9, 11, 0, 0, 0, newYork)))
+	assert.Empty(t, s.calendarSuppressions(time.Date(2024, 1, 10, 11, 0, 0, 0, newYork)))
+}
+
+func Test_solarElevation(t *testing.T) {
+	greenwich := Point{Lat: 51.4769, Lng: -0.0005}
+	// summer solstice at solar noon, 90 - latitude + axial tilt
+	assert.InDelta(t, 61.96, solarElevation(greenwich, time.Date(2024, 6, 20, 12, 2, 0, 0, time.UTC)), 0.1)
+	// winter solstice at solar noon, 90 - latitude - axial tilt
+	assert.InDelta(t, 15.08, solarElevation(greenwich, time.Date(2024, 12, 21, 11, 58, 0, 0, time.UTC)), 0.1)
+
+	// new york sunset on 2024-01-01 is at 16:39 EST
+	newYork, _ := time.LoadLocation("America/New_York")
+	centralPark := Point{Lat: 40.7812, Lng: -73.9665}
+	assert.Greater(t, solarElevation(centralPark, time.Date(2024, 1, 1, 16, 35, 0, 0, newYork)), sunsetElevation)
+	assert.Less(t, solarElevation(centralPark, time.Date(2024, 1, 1, 16, 43, 0, 0, newYork)), sunsetElevation)
+}
+
+func Test_SunSettings(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+	noon := time.Date(2024, 1, 1, 12, 0, 0, 0, newYork)
+	midnight := time.Date(2024, 1, 1, 0, 0, 0, 0, newYork)
+
+	s := SunSettings{Close: "Night", Location: Point{Lat: 40.7812, Lng: -73.9665}}
+	assert.Nil(t, s.parse(nil))
+	allowed, reason := s.allows(ActionClose, noon)
+	assert.Equal(t, false, allowed)
+	assert.Contains(t, reason, "only close at night")
+	allowed, _ = s.allows(ActionClose, midnight)
+	assert.Equal(t, true, allowed)
+	allowed, _ = s.allows(ActionOpen, noon)
+	assert.Equal(t, true, allowed)
+
+	// elevation threshold above the sun at noon makes noon dark
+	elevation := 60.0
+	s.Elevation = &elevation
+	allowed, _ = s.allows(ActionClose, noon)
+	assert.Equal(t, true, allowed)
+
+	// location defaults to circular center or polygon centroid
+	s = SunSettings{Open: "day"}
+	assert.Nil(t, s.parse(distanceGeofence))
+	assert.Equal(t, distanceGeofence.Center, s.Location)
+	s = SunSettings{Open: "day"}
+	assert.Nil(t, s.parse(polygonGeofence))
+	assert.Equal(t, true, polygonGeofence.Close.contains(s.Location))
+
+	// invalid settings
+	assert.NotNil(t, (&SunSettings{Open: "dusk"}).parse(distanceGeofence))
+	assert.NotNil(t, (&SunSettings{Open: "day"}).parse(teslamateGeofence))
+}
+
+func Test_confirmAction_WhenDark(t *testing.T) {
+	distanceGarageDoor.Confirmation = ConfirmationSettings{Fixes: 2, WhenDark: true}
+	distanceGarageDoor.Sun = SunSettings{Location: distanceGeofence.Center}
+	defer func() {
+		distanceGarageDoor.Confirmation = ConfirmationSettings{}
+		distanceGarageDoor.Sun = SunSettings{}
+	}()
+
+	// the sun is never above 90 degrees, so it's always dark
+	elevation := 90.0
+	distanceGarageDoor.Sun.Elevation = &elevation
+	assert.Equal(t, "", distanceTracker.confirmAction(ActionClose))
+	assert.Equal(t, ActionClose, distanceTracker.PendingAction)
+
+	// the sun is never below -90 degrees, so it's never dark and confirmation isn't required
+	elevation = -90.0
+	assert.Equal(t, ActionClose, distanceTracker.confirmAction(ActionClose))
+	assert.Equal(t, "", distanceTracker.PendingAction)
+}
+
+func Test_TopicCondition(t *testing.T) {
+	var conditions []*TopicCondition
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+- topic: home/alarm
+  not_equals: [armed_away, triggered]
+  actions: [open]
+- topic: teslamate/cars/1/battery
+  json_path: state.level
+  above: 20
+  below: 100
+  allow_unknown: true
+`), &conditions))
+	for _, c := range conditions {
+		assert.Nil(t, c.parse())
+	}
+	prevConditions := distanceGarageDoor.Conditions
+	distanceGarageDoor.Conditions = conditions
+	defer func() { distanceGarageDoor.Conditions = prevConditions }()
+
+	assert.Equal(t, []string{"home/alarm", "teslamate/cars/1/battery"}, ConditionTopics())
+
+	// no value received for alarm, battery allows unknown values
+	allowed, reason := distanceGarageDoor.checkConditions(ActionOpen, time.Now())
+	assert.Equal(t, false, allowed)
+	assert.Contains(t, reason, "no value")
+	allowed, _ = distanceGarageDoor.checkConditions(ActionClose, time.Now())
+	assert.Equal(t, true, allowed)
+
+	UpdateConditionTopic("home/alarm", []byte("armed_away"))
+	allowed, _ = distanceGarageDoor.checkConditions(ActionOpen, time.Now())
+	assert.Equal(t, false, allowed)
+	UpdateConditionTopic("home/alarm", []byte("disarmed"))
+	allowed, _ = distanceGarageDoor.checkConditions(ActionOpen, time.Now())
+	assert.Equal(t, true, allowed)
+
+	// numeric comparisons with json path
+	UpdateConditionTopic("teslamate/cars/1/battery", []byte(`{"state": {"level": 15}}`))
+	allowed, reason = distanceGarageDoor.checkConditions(ActionClose, time.Now())
+	assert.Equal(t, false, allowed)
+	assert.Contains(t, reason, "expected above 20")
+	UpdateConditionTopic("teslamate/cars/1/battery", []byte(`{"state": {"level": 80}}`))
+	allowed, _ = distanceGarageDoor.checkConditions(ActionClose, time.Now())
+	assert.Equal(t, true, allowed)
+	// unparseable payloads keep the previous value
+	UpdateConditionTopic("teslamate/cars/1/battery", []byte(`not json`))
+	allowed, _ = distanceGarageDoor.checkConditions(ActionClose, time.Now())
+	assert.Equal(t, true, allowed)
+
+	// invalid conditions
+	assert.NotNil(t, (&TopicCondition{Equals: StateValues{"D"}}).parse())
+	assert.NotNil(t, (&TopicCondition{Topic: "a/b"}).parse())
+	assert.NotNil(t, (&TopicCondition{Topic: "a/+", Equals: StateValues{"D"}}).parse())
+	assert.NotNil(t, (&TopicCondition{Topic: "a/#", Equals: StateValues{"D"}}).parse())
+	assert.NotNil(t, (&TopicCondition{Topic: "a/b", Equals: StateValues{"D"}, Actions: []string{"toggle"}}).parse())
+}
+
+func Test_CheckCircularGeofence_ManualOperation(t *testing.T) {
+	mockGdo := &mocks.GDO{}
+	distanceTracker.GarageDoor.Opener = mockGdo
+	defer mockGdo.AssertExpectations(t)
+
+	distanceGarageDoor.ManualOperation = ManualOperationSettings{SuppressMinutes: 5}
+	defer func() { distanceGarageDoor.ManualOperation = ManualOperationSettings{} }() // restore settings
+
+	// door was opened by hand, so leaving shouldn't close it
+	mockGdo.EXPECT().Status().Return(state.Status{State: state.Open, LastManualOperation: time.Now().Add(-time.Minute)}).Once()
+	distanceTracker.CurDistance = 0
+	distanceTracker.CurrentLocation.Lat = distanceGeofence.Center.Lat + 10
+	distanceTracker.CurrentLocation.Lng = distanceGeofence.Center.Lng
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+
+	// without suppression, leaving should close
+	distanceGarageDoor.ManualOperation.SuppressMinutes = 0
+	mockGdo.EXPECT().SetGarageDoor(ActionClose).Return(nil)
+	distanceTracker.CurDistance = 0
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+}
+
+func Test_CheckGeofence_Startup(t *testing.T) {
+	mockGdo := &mocks.GDO{}
+	distanceTracker.GarageDoor.Opener = mockGdo
+	defer mockGdo.AssertExpectations(t)
+	defer func() { distanceTracker.initialized = true }()
+
+	// first update far from the garage only seeds the tracker's membership, it shouldn't close
+	distanceTracker.initialized = false
+	distanceTracker.CurDistance = 0
+	distanceTracker.CurrentLocation = Point{Lat: distanceGeofence.Center.Lat + 10, Lng: distanceGeofence.Center.Lng}
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+	assert.Equal(t, true, distanceTracker.initialized)
+	assert.Greater(t, distanceTracker.CurDistance, distanceGeofence.CloseDistance)
+
+	// subsequent updates are evaluated normally
+	mockGdo.EXPECT().SetGarageDoor(ActionOpen).Return(nil)
+	distanceTracker.CurrentLocation = distanceGeofence.Center
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+
+	// a tracker that starts at home shouldn't open
+	distanceTracker.initialized = false
+	distanceTracker.CurDistance = 100
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+}
+
+func Test_CheckGeofence_StartupReconcile(t *testing.T) {
+	mockGdo := &mocks.GDO{}
+	distanceTracker.GarageDoor.Opener = mockGdo
+	defer mockGdo.AssertExpectations(t)
+	defer func() {
+		distanceTracker.initialized = true
+		distanceGarageDoor.Startup = StartupSettings{}
+		distanceGarageDoor.reconcileOnce = sync.Once{}
+	}()
+	away := Point{Lat: distanceGeofence.Center.Lat + 10, Lng: distanceGeofence.Center.Lng}
+
+	// door left open while the tracker is away should be closed, only once
+	distanceGarageDoor.Startup.Reconcile = ReconcileClose
+	mockGdo.EXPECT().Status().Return(state.Status{State: state.Open}).Once()
+	mockGdo.EXPECT().SetGarageDoor(ActionClose).Return(nil).Once()
+	distanceTracker.initialized = false
+	distanceTracker.CurrentLocation = away
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+	distanceTracker.initialized = false
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+
+	// alert only logs
+	distanceGarageDoor.reconcileOnce = sync.Once{}
+	distanceGarageDoor.Startup.Reconcile = ReconcileAlert
+	mockGdo.EXPECT().Status().Return(state.Status{State: state.Open}).Once()
+	distanceTracker.initialized = false
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+
+	// nothing to reconcile when the tracker is home
+	distanceGarageDoor.reconcileOnce = sync.Once{}
+	distanceGarageDoor.Startup.Reconcile = ReconcileClose
+	distanceTracker.initialized = false
+	distanceTracker.CurrentLocation = distanceGeofence.Center
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+
+	assert.NotNil(t, StartupSettings{Reconcile: "open"}.validate())
+}
+
+func Test_SaveRestoreState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	defer func() {
+		distanceTracker.CurDistance = 0
+		polygonTracker.InsidePolyCloseGeo, polygonTracker.InsidePolyOpenGeo = false, false
+		distanceTracker.initialized, distanceTracker.restored = true, false
+		Resume(PauseScope{})
+	}()
+
+	distanceTracker.CurDistance = 5
+	polygonTracker.InsidePolyCloseGeo, polygonTracker.InsidePolyOpenGeo = true, true
+	assert.Equal(t, nil, Pause(PauseScope{Door: "0"}, 120*time.Second))
+	assert.Equal(t, nil, Pause(PauseScope{Tracker: fmt.Sprintf("%v", distanceTracker.ID)}, 0))
+	assert.Equal(t, nil, SaveState(path))
+
+	// state is restored on startup
+	distanceTracker.CurDistance = 0
+	polygonTracker.InsidePolyCloseGeo, polygonTracker.InsidePolyOpenGeo = false, false
+	distanceTracker.initialized = false
+	Resume(PauseScope{})
+	assert.Equal(t, nil, RestoreState(path, time.Hour))
+	pauses := Pauses()
+	if assert.Len(t, pauses, 2) {
+		assert.Equal(t, fmt.Sprintf("%v", distanceTracker.ID), pauses[0].Tracker)
+		assert.Equal(t, true, pauses[0].Until.IsZero())
+		assert.Equal(t, "0", pauses[1].Door)
+		assert.InDelta(t, 120, pauses[1].Remaining, 1)
+	}
+	assert.Equal(t, 5.0, distanceTracker.CurDistance)
+	assert.Equal(t, true, polygonTracker.InsidePolyCloseGeo)
+	assert.Equal(t, true, polygonTracker.InsidePolyOpenGeo)
+	assert.Equal(t, false, distanceTracker.initialized)
+	assert.Equal(t, true, distanceTracker.restored)
+
+	// the first update after restoring only seeds the tracker's membership, even if it left while we were stopped
+	mockGdo := &mocks.GDO{}
+	distanceGarageDoor.Opener = mockGdo
+	defer mockGdo.AssertExpectations(t)
+	Resume(PauseScope{})
+	distanceTracker.CurDistance = 0
+	distanceTracker.CurrentLocation = Point{Lat: distanceGeofence.Center.Lat + 10, Lng: distanceGeofence.Center.Lng}
+	assert.Equal(t, true, checkGeofenceWrapper(distanceTracker))
+	assert.Equal(t, true, distanceTracker.initialized)
+	assert.Equal(t, false, distanceTracker.restored)
+
+	// subsequent updates are evaluated normally
+	mockGdo.EXPECT().SetGarageDoor(ActionOpen).Return(nil).Once()
+	distanceTracker.CurrentLocation = distanceGeofence.Center
+	assert.Equal(t, true, checkGeofenceWrapper(distanceTracker))
+
+	// stale state is discarded
+	distanceTracker.CurDistance = 0
+	Resume(PauseScope{})
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, nil, RestoreState(path, time.Millisecond))
+	assert.Len(t, Pauses(), 0)
+	assert.Equal(t, 0.0, distanceTracker.CurDistance)
+
+	// missing state file is not an error, but a corrupt one is
+	assert.Equal(t, nil, RestoreState(filepath.Join(t.TempDir(), "missing.json"), time.Hour))
+	assert.Equal(t, nil, os.WriteFile(path, []byte("not json"), 0644))
+	assert.NotNil(t, RestoreState(path, time.Hour))
+}
+
+func Test_ProcessFix_Concurrent(t *testing.T) {
+	mockGdo := &mocks.GDO{}
+	distanceGarageDoor.Opener = mockGdo
+	defer mockGdo.AssertExpectations(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	// state is saved and statuses are read while the tracker is evaluated (run with -race); the tracker stays
+	// outside the geofences
+	far := distanceGeofence.Center.Lat + 10
+	distanceTracker.CurrentLocation.Lat = far
+	distanceTracker.CurDistance = distance(distanceTracker.CurrentLocation, distanceGeofence.Center)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			ProcessFix(distanceTracker, Fix{Point: Point{Lat: far + float64(i)/1000, Lng: distanceGeofence.Center.Lng}})
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, SaveState(path))
+		assert.Len(t, TrackerStatuses("0", fmt.Sprintf("%v", distanceTracker.ID)), 1)
+	}
+	<-done
+	assert.InDelta(t, far+0.099, distanceTracker.CurrentLocation.Lat, 1e-9)
+}
+
+func Test_CheckGeofence_History(t *testing.T) {
+	assert.Equal(t, nil, history.Open(filepath.Join(t.TempDir(), "history.jsonl"), history.Retention{}))
+	defer history.Close()
+	mockGdo := &mocks.GDO{}
+	distanceTracker.GarageDoor.Opener = mockGdo
+	defer mockGdo.AssertExpectations(t)
+
+	// leaving while paused is suppressed
+	Pause(PauseScope{}, 0)
+	distanceTracker.CurDistance = 0
+	distanceTracker.CurrentLocation = Point{Lat: distanceGeofence.Center.Lat + 10, Lng: distanceGeofence.Center.Lng}
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+	Resume(PauseScope{})
+
+	// arriving opens the garage
+	mockGdo.EXPECT().SetGarageDoor(ActionOpen).Return(nil)
+	distanceTracker.CurrentLocation = distanceGeofence.Center
+	assert.Equal(t, checkGeofenceWrapper(distanceTracker), true)
+
+	events := history.Query(history.Filter{Door: "0", Tracker: fmt.Sprintf("%v", distanceTracker.ID)})
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []string{history.TypeFix, history.TypeTransition, history.TypeSuppressed, history.TypeFix, history.TypeTransition, history.TypeResult}, types)
+	assert.Equal(t, "garage operations are paused for all garage doors", events[2].Reason)
+	assert.Equal(t, ActionOpen, events[5].Action)
+	assert.Equal(t, true, *events[5].Success)
+}
+
+func Test_CheckGeofence_Decision(t *testing.T) {
+	mockGdo := &mocks.GDO{}
+	distanceTracker.GarageDoor.Opener = mockGdo
+	defer mockGdo.AssertExpectations(t)
+	away := Point{Lat: distanceGeofence.Center.Lat + 10, Lng: distanceGeofence.Center.Lng}
+
+	// first update after startup only seeds membership
+	distanceTracker.initialized = false
+	distanceTracker.CurDistance = 0
+	distanceTracker.CurrentLocation = away
+	d := CheckGeofence(distanceTracker)
+	assert.Equal(t, GuardInitializing, d.BlockedBy)
+	assert.Equal(t, false, d.Membership.Home)
+
+	// arriving while paused is blocked
+	Pause(PauseScope{}, 0)
+	distanceTracker.CurrentLocation = distanceGeofence.Center
+	d = CheckGeofence(distanceTracker)
+	Resume(PauseScope{})
+	assert.Equal(t, ActionOpen, d.Transition)
+	assert.Equal(t, ActionOpen, d.Action)
+	assert.Equal(t, GuardPaused, d.BlockedBy)
+	assert.Equal(t, false, d.Executed)
+	assert.Equal(t, false, d.PrevMembership.OnOpenSide)
+	assert.Equal(t, true, d.Membership.OnOpenSide)
+	assert.Greater(t, d.Inputs.PrevDistance, distanceGeofence.OpenDistance)
+	assert.Less(t, d.Inputs.Distance, distanceGeofence.OpenDistance)
+
+	// leaving is pending confirmation
+	distanceGarageDoor.Confirmation = ConfirmationSettings{Fixes: 2}
+	distanceTracker.CurrentLocation = away
+	d = CheckGeofence(distanceTracker)
+	assert.Equal(t, ActionClose, d.Transition)
+	assert.Equal(t, ActionClose, d.PendingAction)
+	assert.Equal(t, GuardConfirmation, d.BlockedBy)
+
+	// and executes once confirmed
+	mockGdo.EXPECT().SetGarageDoor(ActionClose).Return(nil)
+	d = CheckGeofence(distanceTracker)
+	distanceGarageDoor.Confirmation = ConfirmationSettings{}
+	assert.Equal(t, ActionClose, d.Action)
+	assert.Equal(t, "", d.BlockedBy)
+	assert.Equal(t, true, d.Executed)
+	for i := 0; i < 10 && distanceGarageDoor.OpLock.Load(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// decisions are retained per tracker
+	decisions := Decisions("0", fmt.Sprintf("%v", distanceTracker.ID), 2)
+	assert.Len(t, decisions, 2)
+	assert.Equal(t, d, decisions[1])
+	assert.Contains(t, d.String(), "action close executed true")
+}
+
+func Test_Statuses(t *testing.T) {
+	mockGdo := &mocks.GDO{}
+	distanceTracker.GarageDoor.Opener = mockGdo
+	defer mockGdo.AssertExpectations(t)
+	util.Config.Global.OpCooldown = 1
+	defer func() { util.Config.Global.OpCooldown = 0 }()
+
+	// arrive and operate the garage door, which starts the cooldown
+	distanceGarageDoor.OpLock.Store(false)
+	distanceTracker.initialized = true
+	distanceGarageDoor.cooldownUntil = time.Time{}
+	distanceTracker.CurrentLocation = Point{Lat: distanceGeofence.Center.Lat + 10, Lng: distanceGeofence.Center.Lng}
+	CheckGeofence(distanceTracker)
+	distanceTracker.CurrentLocation = distanceGeofence.Center
+	mockGdo.EXPECT().SetGarageDoor(ActionOpen).Return(fmt.Errorf("opener offline")).Times(3)
+	CheckGeofence(distanceTracker)
+	for i := 0; i < 10; i++ {
+		distanceGarageDoor.statusLock.Lock()
+		cooldownStarted := !distanceGarageDoor.cooldownUntil.IsZero()
+		distanceGarageDoor.statusLock.Unlock()
+		if cooldownStarted {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mockGdo.EXPECT().Status().Return(state.Status{State: state.Closed})
+	doors := DoorStatuses("0")
+	assert.Len(t, doors, 1)
+	assert.Equal(t, "circular", doors[0].Geofence)
+	assert.Contains(t, doors[0].Trackers, fmt.Sprintf("%v", distanceTracker.ID))
+	assert.Equal(t, true, doors[0].OpLock)
+	assert.InDelta(t, 60, doors[0].CooldownRemaining, 1)
+	assert.Equal(t, state.Closed, doors[0].Status.State)
+	if assert.NotNil(t, doors[0].LastResult) {
+		assert.Equal(t, ActionOpen, doors[0].LastResult.Action)
+		assert.Equal(t, false, doors[0].LastResult.Success)
+		assert.Equal(t, "opener offline", doors[0].LastResult.Error)
+	}
+
+	trackers := TrackerStatuses("0", fmt.Sprintf("%v", distanceTracker.ID))
+	assert.Len(t, trackers, 1)
+	assert.Equal(t, distanceGeofence.Center, trackers[0].Location)
+	assert.Equal(t, true, trackers[0].Membership.Home)
+	assert.WithinDuration(t, time.Now(), trackers[0].LastUpdate, time.Second)
+
+	// release the cooldown for subsequent tests
+	distanceGarageDoor.OpLock.Store(false)
+}
+
+func Test_SendCommand(t *testing.T) {
+	mockGdo := &mocks.GDO{}
+	distanceGarageDoor.Opener = mockGdo
+	defer mockGdo.AssertExpectations(t)
+	distanceGarageDoor.OpLock.Store(false)
+	waitForUnlock := func() {
+		for i := 0; i < 10 && distanceGarageDoor.OpLock.Load(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// invalid actions and paused operations are rejected
+	_, err := distanceGarageDoor.SendCommand("stop")
+	assert.Error(t, err)
+	Pause(PauseScope{}, 0)
+	_, err = distanceGarageDoor.SendCommand(ActionOpen)
+	Resume(PauseScope{})
+	assert.EqualError(t, err, "garage operations are paused for all garage doors")
+
+	// successful command
+	mockGdo.EXPECT().SetGarageDoor(ActionOpen).Return(nil).Once()
+	c, err := distanceGarageDoor.SendCommand(ActionOpen)
+	assert.NoError(t, err)
+	<-c.Done()
+	assert.Equal(t, CommandSucceeded, c.Snapshot().Status)
+	found, ok := FindCommand(c.ID)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, ActionOpen, found.Action)
+
+	// a locked garage door rejects commands
+	distanceGarageDoor.OpLock.Store(true)
+	_, err = distanceGarageDoor.SendCommand(ActionClose)
+	assert.EqualError(t, err, "garage door is locked due to either cooldown or current activity")
+	distanceGarageDoor.OpLock.Store(false)
+	waitForUnlock()
+
+	// the opener is retried before the command fails
+	mockGdo.EXPECT().SetGarageDoor(ActionClose).Return(fmt.Errorf("opener offline")).Times(3)
+	c, err = distanceGarageDoor.SendCommand(ActionClose)
+	assert.NoError(t, err)
+	<-c.Done()
+	result := c.Snapshot()
+	assert.Equal(t, CommandFailed, result.Status)
+	assert.Equal(t, "opener offline", result.Error)
+	waitForUnlock()
+
+	// concurrent commands only operate the garage door once; the opener is held until every command was sent
+	release := make(chan struct{})
+	mockGdo.EXPECT().SetGarageDoor(ActionOpen).Run(func(string) { <-release }).Return(nil).Once()
+	var sent sync.WaitGroup
+	var accepted atomic.Int32
+	var pending *Command
+	for i := 0; i < 10; i++ {
+		sent.Add(1)
+		go func() {
+			defer sent.Done()
+			if c, err := distanceGarageDoor.SendCommand(ActionOpen); err == nil {
+				accepted.Add(1)
+				pending = c
+			}
+		}()
+	}
+	sent.Wait()
+	close(release)
+	<-pending.Done()
+	assert.Equal(t, int32(1), accepted.Load())
+	waitForUnlock()
+}
+
+func Test_Pause(t *testing.T) {
+	defer Resume(PauseScope{})
+	trackerID := fmt.Sprintf("%v", distanceTracker.ID)
+
+	// scopes must match the config
+	assert.NotNil(t, Pause(PauseScope{Door: "99"}, 0))
+	assert.NotNil(t, Pause(PauseScope{Tracker: "missing"}, 0))
+	assert.NotNil(t, Pause(PauseScope{Door: "0", Tracker: trackerID}, 0))
+
+	// a garage door pause only applies to that garage door and its trackers
+	assert.Equal(t, nil, Pause(PauseScope{Door: "0"}, 0))
+	assert.NotNil(t, distanceGarageDoor.pause())
+	assert.NotNil(t, distanceTracker.pause())
+	assert.Nil(t, polygonGarageDoor.pause())
+	assert.Equal(t, nil, Resume(PauseScope{Door: "0"}))
+	assert.Nil(t, distanceTracker.pause())
+
+	// a tracker pause doesn't pause its garage door
+	assert.Equal(t, nil, Pause(PauseScope{Tracker: trackerID}, 0))
+	assert.Nil(t, distanceGarageDoor.pause())
+	assert.NotNil(t, distanceTracker.pause())
+
+	// resuming a garage door doesn't override a pause of all garage doors, but resuming all resumes everything
+	assert.Equal(t, nil, Pause(PauseScope{}, 0))
+	assert.Equal(t, nil, Resume(PauseScope{Door: "0"}))
+	assert.NotNil(t, distanceGarageDoor.pause())
+	assert.Equal(t, nil, Resume(PauseScope{}))
+	assert.Len(t, Pauses(), 0)
+
+	// finite pauses resume once they time out, unless they're replaced
+	assert.Equal(t, nil, Pause(PauseScope{Door: "0"}, 20*time.Millisecond))
+	assert.Equal(t, nil, Pause(PauseScope{Tracker: trackerID}, 20*time.Millisecond))
+	assert.Equal(t, nil, Pause(PauseScope{Tracker: trackerID}, time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	pauses := Pauses()
+	if assert.Len(t, pauses, 1) {
+		assert.Equal(t, trackerID, pauses[0].Tracker)
+		assert.InDelta(t, 60, pauses[0].Remaining, 1)
+	}
+}
+
+func Test_parseGarageDoorIDs(t *testing.T) {
+	// ids default to the garage door's position in the config
+	doors := []*GarageDoor{{ID: "main", Name: "Main Garage"}, {}, {Name: "Side Garage"}}
+	assert.Equal(t, nil, parseGarageDoorIDs(doors))
+	assert.Equal(t, "main", doors[0].ID)
+	assert.Equal(t, "1", doors[1].ID)
+	assert.Equal(t, "2", doors[2].ID)
+	assert.Equal(t, "Main Garage", doors[0].String())
+	assert.Equal(t, "1", doors[1].String())
+
+	// ids and names must be unique, including default ids
+	assert.NotNil(t, parseGarageDoorIDs([]*GarageDoor{{ID: "main"}, {ID: "main"}}))
+	assert.NotNil(t, parseGarageDoorIDs([]*GarageDoor{{}, {ID: "0"}}))
+	assert.NotNil(t, parseGarageDoorIDs([]*GarageDoor{{Name: "Main Garage"}, {Name: "main garage"}}))
+	assert.NotNil(t, parseGarageDoorIDs([]*GarageDoor{{ID: "main/side"}}))
+}
+
+func Test_PauseCommand(t *testing.T) {
+	var published [][]PauseStatus
+	OnPauseChanged(func(pauses []PauseStatus) { published = append(published, pauses) })
+	defer OnPauseChanged(nil)
+	defer Resume(PauseScope{})
+
+	// json commands may be scoped and timed
+	c, err := ParsePauseCommand([]byte(`{"command": "pause", "duration": 600, "door": "0"}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, PauseCommand{Command: CommandPause, Duration: 600, PauseScope: PauseScope{Door: "0"}}, c)
+	assert.Equal(t, nil, c.Execute())
+	if assert.Len(t, published, 1) && assert.Len(t, published[0], 1) {
+		assert.Equal(t, "0", published[0][0].Door)
+		assert.InDelta(t, 600, published[0][0].Remaining, 1)
+	}
+
+	// plain commands apply to all garage doors
+	c, err = ParsePauseCommand([]byte(" RESUME\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, PauseCommand{Command: CommandResume}, c)
+	assert.Equal(t, nil, c.Execute())
+	if assert.Len(t, published, 2) {
+		assert.Len(t, published[1], 0)
+	}
+
+	// invalid commands and scopes are errors
+	_, err = ParsePauseCommand([]byte("stop"))
+	assert.NotNil(t, err)
+	_, err = ParsePauseCommand([]byte(`{"command": "pause"`))
+	assert.NotNil(t, err)
+	c, _ = ParsePauseCommand([]byte(`{"command": "pause", "door": "missing"}`))
+	assert.NotNil(t, c.Execute())
+	assert.Len(t, published, 2)
+}
+
+func Test_CheckCircularGeofence_OpenETA(t *testing.T) {
+	mockGdo := &mocks.GDO{}
+	distanceGarageDoor.Opener = mockGdo
+	defer mockGdo.AssertExpectations(t)
+	distanceGeofence.OpenETA = 15
+	defer func() {
+		distanceGeofence.OpenETA = 0
+		distanceGarageDoor.Confirmation = ConfirmationSettings{}
+		distanceTracker.HasVelocity, distanceTracker.WithinOpenETA, distanceTracker.OpenedByETA = false, false, false
+		distanceTracker.PendingAction = ""
+	}()
+	beyondOpenGeo := func(km float64) Point {
+		return Point{Lat: distanceGeofence.Center.Lat + (distanceGeofence.OpenDistance+km)/111.195, Lng: distanceGeofence.Center.Lng}
+	}
+	// approaches the garage at 36 km/h (10 m/s) from 1 km beyond the open geofence, returning each evaluation's decision;
+	// beforeEntry is called before the tracker enters the open geofence
+	approach := func(beforeEntry func()) (decisions []Decision) {
+		distanceTracker.CurrentLocation = beyondOpenGeo(2)
+		distanceTracker.CurDistance = 100
+		distanceTracker.HasVelocity, distanceTracker.WithinOpenETA, distanceTracker.OpenedByETA = false, false, false
+		for _, p := range []Point{beyondOpenGeo(1), beyondOpenGeo(.1), beyondOpenGeo(.05), distanceGeofence.Center} {
+			if p == distanceGeofence.Center {
+				beforeEntry()
+			}
+			distanceTracker.ApplyFix(Fix{Point: p, Velocity: 36, HasVelocity: true})
+			decisions = append(decisions, CheckGeofence(distanceTracker))
+			for i := 0; i < 10 && distanceGarageDoor.OpLock.Load(); i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		return
+	}
+
+	// with confirmation, the estimated arrival is confirmed while the tracker is still approaching, and entering
+	// the open geofence doesn't open again
+	distanceGarageDoor.Confirmation = ConfirmationSettings{Fixes: 2}
+	mockGdo.EXPECT().SetGarageDoor(ActionOpen).Return(nil).Once()
+	decisions := approach(func() {})
+	assert.Equal(t, GuardConfirmation, decisions[1].BlockedBy)
+	assert.Equal(t, true, decisions[2].Executed)
+	assert.Equal(t, "", decisions[3].Action)
+	assert.Equal(t, "", distanceTracker.PendingAction)
+	mockGdo.AssertExpectations(t)
+
+	// if the estimated arrival is blocked, entering the open geofence opens as usual
+	distanceGarageDoor.Confirmation = ConfirmationSettings{}
+	assert.Equal(t, nil, Pause(PauseScope{Door: distanceGarageDoor.ID}, 0))
+	mockGdo.EXPECT().SetGarageDoor(ActionOpen).Return(nil).Once()
+	decisions = approach(func() { assert.Equal(t, nil, Resume(PauseScope{})) })
+	assert.Equal(t, GuardPaused, decisions[1].BlockedBy)
+	assert.Equal(t, ActionOpen, decisions[3].Action)
+	assert.Equal(t, true, decisions[3].Executed)
+}
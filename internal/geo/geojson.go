@@ -0,0 +1,145 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+type (
+	// geojson schema to parse polygon geofences from a FeatureCollection, see https://datatracker.ietf.org/doc/html/rfc7946
+	// each feature must define `properties.role` as `open`, `close`, or `restricted`
+	geoJSON struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type       string                 `json:"type"`
+			Properties map[string]interface{} `json:"properties"`
+			Geometry   *struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+)
+
+// loads geojson file and overrides polygon geofence points with parsed data
+func loadGeoJSONFile(p *PolygonGeofence) error {
+	fileContent, err := os.ReadFile(p.GeoJSONFile)
+	if err != nil {
+		return fmt.Errorf("could not read file %s, received error: %v", p.GeoJSONFile, err)
+	}
+
+	var g geoJSON
+	if err = json.Unmarshal(fileContent, &g); err != nil {
+		return fmt.Errorf("could not load geojson from file %s, received error: %v", p.GeoJSONFile, err)
+	}
+	if g.Type != "FeatureCollection" {
+		return fmt.Errorf("geojson file %s must contain a FeatureCollection, found type '%s'", p.GeoJSONFile, g.Type)
+	}
+
+	// features from the geojson file replace any inline polygons; multiple features with the same role are combined
+	var open, close, restricted Polygons
+	for i, feature := range g.Features {
+		role, _ := feature.Properties["role"].(string)
+		role = strings.ToLower(role)
+		// features must have a role of `open`, `close`, or `restricted` or they're considered irrelevant
+		if role != "open" && role != "close" && role != "restricted" {
+			logger.Debugf("Skipping feature %d in geojson file %s, properties.role is not open, close, or restricted", i, p.GeoJSONFile)
+			continue
+		}
+		if feature.Geometry == nil {
+			return fmt.Errorf("feature %d (%s) in geojson file %s has no geometry", i, role, p.GeoJSONFile)
+		}
+
+		polygons, err := parseGeoJSONGeometry(feature.Geometry.Type, feature.Geometry.Coordinates)
+		if err != nil {
+			return fmt.Errorf("invalid geometry for feature %d (%s) in geojson file %s: %v", i, role, p.GeoJSONFile, err)
+		}
+
+		switch role {
+		case "open":
+			open = append(open, polygons...)
+		case "close":
+			close = append(close, polygons...)
+		case "restricted":
+			restricted = append(restricted, polygons...)
+		}
+	}
+
+	if len(open)+len(close)+len(restricted) == 0 {
+		return fmt.Errorf("geojson file %s has no features with properties.role set to open, close, or restricted", p.GeoJSONFile)
+	}
+	if len(open) > 0 {
+		p.Open = open
+	}
+	if len(close) > 0 {
+		p.Close = close
+	}
+	if len(restricted) > 0 {
+		p.Restricted = restricted
+	}
+	return nil
+}
+
+// parses the coordinates of a Polygon or MultiPolygon geometry; the first ring of each
+// polygon is its outer boundary, and any subsequent rings are holes
+func parseGeoJSONGeometry(geometryType string, coordinates json.RawMessage) (Polygons, error) {
+	var rawPolygons [][][][]float64
+	switch geometryType {
+	case "Polygon":
+		var polygon [][][]float64
+		if err := json.Unmarshal(coordinates, &polygon); err != nil {
+			return nil, fmt.Errorf("could not parse Polygon coordinates: %v", err)
+		}
+		rawPolygons = append(rawPolygons, polygon)
+	case "MultiPolygon":
+		if err := json.Unmarshal(coordinates, &rawPolygons); err != nil {
+			return nil, fmt.Errorf("could not parse MultiPolygon coordinates: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("geometry type must be Polygon or MultiPolygon, found '%s'", geometryType)
+	}
+
+	var polygons Polygons
+	for _, rings := range rawPolygons {
+		if len(rings) == 0 {
+			return nil, fmt.Errorf("polygon has no rings")
+		}
+		var polygon Polygon
+		var err error
+		if polygon.Outer, err = parseGeoJSONRing(rings[0]); err != nil {
+			return nil, err
+		}
+		for _, ring := range rings[1:] {
+			hole, err := parseGeoJSONRing(ring)
+			if err != nil {
+				return nil, err
+			}
+			polygon.Holes = append(polygon.Holes, hole)
+		}
+		polygons = append(polygons, polygon)
+	}
+	return polygons, nil
+}
+
+// parses a geojson linear ring of [longitude, latitude] positions into a list of points
+func parseGeoJSONRing(ring [][]float64) ([]Point, error) {
+	if len(ring) < 3 {
+		return nil, fmt.Errorf("polygon ring must have at least 3 positions, found %d", len(ring))
+	}
+	points := make([]Point, 0, len(ring))
+	for _, position := range ring {
+		if len(position) < 2 {
+			return nil, fmt.Errorf("position %v must contain a longitude and latitude", position)
+		}
+		lng, lat := position[0], position[1]
+		if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+			return nil, fmt.Errorf("position %v is out of range; geojson positions must be in [longitude, latitude] order", position)
+		}
+		points = append(points, Point{Lat: lat, Lng: lng})
+	}
+	return points, nil
+}
@@ -0,0 +1,69 @@
+package geo
+
+import (
+	"math"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+type (
+	// defines a range of allowed headings (bearings in degrees, 0 = north, clockwise) for a tracker approaching the garage;
+	// the range is read clockwise from `from` to `to`, so it may wrap around north, e.g. from 300 to 30
+	HeadingRange struct {
+		From float64 `yaml:"from"`
+		To   float64 `yaml:"to"`
+	}
+)
+
+// minimum distance in km a tracker must move between fixes for a heading to be derived from them;
+// shorter movements are too easily dominated by gps noise
+const minHeadingDistance = 0.01
+
+func (h HeadingRange) isDefined() bool {
+	return h.From != 0 || h.To != 0
+}
+
+// indicates whether the heading falls within the range, accounting for ranges that wrap around north
+func (h HeadingRange) contains(heading float64) bool {
+	from, to, heading := normalizeBearing(h.From), normalizeBearing(h.To), normalizeBearing(heading)
+	if from <= to {
+		return heading >= from && heading <= to
+	}
+	return heading >= from || heading <= to
+}
+
+// indicates whether the tracker's current heading permits an open action
+// if a heading range is defined but the tracker's heading is unknown, the open action is not permitted
+func (h HeadingRange) allowsOpen(tracker *Tracker) bool {
+	if !h.isDefined() {
+		return true
+	}
+	if !tracker.HasCourse {
+		logger.Infof("Tracker %v entered the open geofence, but its heading is unknown and an open heading range is defined; will not open", tracker.ID)
+		return false
+	}
+	if !h.contains(tracker.Course) {
+		logger.Infof("Tracker %v entered the open geofence with heading %.0f, outside of the allowed open heading range %.0f-%.0f; will not open", tracker.ID, tracker.Course, h.From, h.To)
+		return false
+	}
+	return true
+}
+
+// calculates the initial bearing in degrees from point1 to point2
+func bearing(point1 Point, point2 Point) float64 {
+	lat1 := toRadians(point1.Lat)
+	lat2 := toRadians(point2.Lat)
+	deltaLon := toRadians(point2.Lng - point1.Lng)
+	y := math.Sin(deltaLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(deltaLon)
+	return normalizeBearing(math.Atan2(y, x) * 180 / math.Pi)
+}
+
+// normalizes a bearing to the range [0, 360)
+func normalizeBearing(b float64) float64 {
+	b = math.Mod(b, 360)
+	if b < 0 {
+		b += 360
+	}
+	return b
+}
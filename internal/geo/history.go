@@ -0,0 +1,37 @@
+package geo
+
+import (
+	"fmt"
+
+	"github.com/brchri/tesla-geogdo/internal/history"
+)
+
+// records a history event for the tracker and its garage door
+func recordEvent(tracker *Tracker, e history.Event) {
+	e.Tracker = fmt.Sprintf("%v", tracker.ID)
+	recordDoorEvent(tracker.GarageDoor, e)
+}
+
+// records a history event for the garage door
+func recordDoorEvent(g *GarageDoor, e history.Event) {
+	e.Door = g.ID
+	history.Record(e)
+}
+
+// records that the action was not executed for the tracker, and why
+func recordSuppressed(tracker *Tracker, action, reason string) {
+	recordEvent(tracker, history.Event{Type: history.TypeSuppressed, Action: action, Reason: reason})
+}
+
+// records the outcome of operating the garage door for the tracker, or for a command if the tracker is nil
+func recordResult(g *GarageDoor, tracker *Tracker, action string, err error) {
+	success := err == nil
+	e := history.Event{Type: history.TypeResult, Action: action, Success: &success}
+	if err != nil {
+		e.Reason = err.Error()
+	}
+	if tracker != nil {
+		e.Tracker = fmt.Sprintf("%v", tracker.ID)
+	}
+	recordDoorEvent(g, e)
+}
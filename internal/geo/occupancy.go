@@ -0,0 +1,39 @@
+package geo
+
+type (
+	// defines whether a garage door shared by multiple trackers should consider the other trackers
+	// before operating; a tracker occupies the garage door while it's home (see GeofenceInterface.isHome)
+	OccupancySettings struct {
+		CloseWhenEmpty bool `yaml:"close_when_empty,omitempty"` // only close when the last tracker leaves
+		OpenWhenEmpty  bool `yaml:"open_when_empty,omitempty"`  // only open for the first tracker to arrive
+	}
+)
+
+// returns the IDs of the garage door's trackers, other than the supplied tracker, that are currently home
+// trackers that haven't reported a location yet are not considered occupants
+func (g *GarageDoor) occupants(exclude *Tracker) []interface{} {
+	var ids []interface{}
+	for _, t := range g.Trackers {
+		if t == exclude || !t.hasLocation() {
+			continue
+		}
+		if g.Geofence.isHome(t) {
+			ids = append(ids, t.ID)
+		}
+	}
+	return ids
+}
+
+// returns the IDs of the other trackers preventing the action based on the garage door's occupancy settings,
+// or nil if the action may proceed
+func (g *GarageDoor) occupancyBlockers(tracker *Tracker, action string) []interface{} {
+	if (action == ActionClose && g.Occupancy.CloseWhenEmpty) || (action == ActionOpen && g.Occupancy.OpenWhenEmpty) {
+		return g.occupants(tracker)
+	}
+	return nil
+}
+
+// indicates whether the tracker has reported a location or geofence since startup
+func (t *Tracker) hasLocation() bool {
+	return t.CurrentLocation.IsPointDefined() || t.CurGeofence != ""
+}
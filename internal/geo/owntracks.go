@@ -0,0 +1,88 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type (
+	// defines a native owntracks location topic for a tracker, along with optional filters
+	// to discard low quality or outdated fixes
+	OwnTracksSettings struct {
+		Topic       string  `yaml:"topic,omitempty"`        // owntracks topic for the device, e.g. owntracks/user/phone
+		MaxAccuracy float64 `yaml:"max_accuracy,omitempty"` // in meters; fixes reporting an accuracy radius larger than this are discarded
+		MaxAge      int     `yaml:"max_age,omitempty"`      // in seconds; fixes with a timestamp older than this are discarded
+	}
+
+	// owntracks location payload, see https://owntracks.org/booklet/tech/json/#_typelocation
+	// optional numeric fields are pointers so we can distinguish between omitted and zero values
+	ownTracksPayload struct {
+		Type string   `json:"_type"`
+		Lat  *float64 `json:"lat"`
+		Lon  *float64 `json:"lon"`
+		Acc  *float64 `json:"acc"`
+		Vel  *float64 `json:"vel"`
+		Cog  *float64 `json:"cog"`
+		Alt  *float64 `json:"alt"`
+		Tst  int64    `json:"tst"`
+	}
+)
+
+// parses an owntracks payload into a Fix
+// only `_type: location` payloads contain a usable fix; all other types (lwt, waypoint, card, etc.)
+// return an empty Fix and no error, indicating there's nothing to process
+func ParseOwnTracksPayload(payload []byte) (Fix, error) {
+	var p ownTracksPayload
+	var f Fix
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return f, fmt.Errorf("could not unmarshal owntracks payload, received error: %v", err)
+	}
+	if p.Type != "location" {
+		return f, nil
+	}
+	if p.Lat == nil || p.Lon == nil {
+		return f, fmt.Errorf("owntracks location payload is missing lat or lon")
+	}
+
+	f.Lat = *p.Lat
+	f.Lng = *p.Lon
+	if p.Acc != nil {
+		f.Accuracy = *p.Acc
+		f.HasAccuracy = true
+	}
+	if p.Vel != nil {
+		f.Velocity = *p.Vel
+		f.HasVelocity = true
+	}
+	// owntracks reports a negative cog when the course is unknown
+	if p.Cog != nil && *p.Cog >= 0 {
+		f.Course = *p.Cog
+		f.HasCourse = true
+	}
+	if p.Alt != nil {
+		f.Altitude = *p.Alt
+	}
+	if p.Tst > 0 {
+		f.Timestamp = time.Unix(p.Tst, 0)
+	}
+	return f, nil
+}
+
+// validates a fix against the tracker's owntracks filters and the last accepted fix;
+// returns an error describing why the fix should be discarded, or nil if it's valid
+func (t *Tracker) ValidateFix(f Fix) error {
+	if f.HasAccuracy && t.OwnTracks.MaxAccuracy > 0 && f.Accuracy > t.OwnTracks.MaxAccuracy {
+		return fmt.Errorf("accuracy of %.0fm exceeds max_accuracy of %.0fm", f.Accuracy, t.OwnTracks.MaxAccuracy)
+	}
+	if f.Timestamp.IsZero() {
+		return nil // nothing else to validate without a timestamp
+	}
+	if !t.LastFixTime.IsZero() && !f.Timestamp.After(t.LastFixTime) {
+		return fmt.Errorf("fix timestamp %s is not newer than last accepted fix at %s", f.Timestamp.Format(time.RFC3339), t.LastFixTime.Format(time.RFC3339))
+	}
+	if t.OwnTracks.MaxAge > 0 && time.Since(f.Timestamp) > time.Duration(t.OwnTracks.MaxAge)*time.Second {
+		return fmt.Errorf("fix timestamp %s is older than max_age of %ds", f.Timestamp.Format(time.RFC3339), t.OwnTracks.MaxAge)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package geo
+
+import (
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// returns a channel of location updates for the tracker; if the tracker publishes lat and lng to separate topics
+// and a pairing_window is configured, the lat and lng halves are buffered and only emitted as a single complete fix
+// once both have arrived, or once the pairing window expires (in which case the received half is emitted on its own
+// and will be paired with the tracker's last known value for the other half, so the anti-flapping guards apply again
+// until the flapping window has passed)
+//
+// all other trackers receive complete fixes, so updates are passed through untouched
+func (t *Tracker) PairLocationUpdates() <-chan Fix {
+	if !t.usesSplitTopics() || t.PairingWindow <= 0 {
+		return t.LocationUpdate
+	}
+
+	paired := make(chan Fix)
+	go func() {
+		defer close(paired)
+		var pending Fix
+		var timeout <-chan time.Time
+		window := time.Duration(t.PairingWindow) * time.Second
+
+		flush := func() {
+			if !pending.IsPointDefined() && pending.Point != (Point{}) {
+				t.lastHalfPoint.Store(time.Now().UnixNano())
+			}
+			if pending.Point != (Point{}) {
+				paired <- pending
+			}
+			pending = Fix{}
+			timeout = nil
+		}
+
+		for {
+			select {
+			case f, ok := <-t.LocationUpdate:
+				if !ok {
+					flush()
+					return
+				}
+				// updates without a location (e.g. heading, speed, or state) don't need pairing
+				if f.Point == (Point{}) {
+					paired <- f
+					continue
+				}
+				// if we receive a second value for a half we're already holding, the other half was
+				// never published, so send what we have before starting a new pair
+				if (f.Lat != 0 && pending.Lat != 0) || (f.Lng != 0 && pending.Lng != 0) {
+					logger.Debugf("Received new half-point for tracker %v before pairing completed, processing previous half-point on its own", t.ID)
+					flush()
+				}
+				if f.Lat != 0 {
+					pending.Lat = f.Lat
+				}
+				if f.Lng != 0 {
+					pending.Lng = f.Lng
+				}
+				if pending.IsPointDefined() {
+					flush()
+				} else if timeout == nil {
+					timeout = time.After(window)
+				}
+			case <-timeout:
+				logger.Debugf("Pairing window expired for tracker %v, processing half-point on its own", t.ID)
+				flush()
+			}
+		}
+	}()
+	return paired
+}
+
+// indicates whether the tracker publishes lat and lng to separate topics
+func (t *Tracker) usesSplitTopics() bool {
+	return t.LatTopic != "" || t.LngTopic != ""
+}
+
+// indicates whether every fix processed for this tracker contains both lat and lng,
+// meaning the tracker can't "teleport" by pairing a new lat with an old lng (or vice versa)
+// a paired tracker that recently processed a lone half-point may have teleported, so isn't considered atomic until
+// the flapping window has passed
+func (t *Tracker) receivesAtomicFixes() bool {
+	if !t.usesSplitTopics() {
+		return true
+	}
+	return t.PairingWindow > 0 && time.Since(time.Unix(0, t.lastHalfPoint.Load())) >= flapWindow
+}
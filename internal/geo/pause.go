@@ -0,0 +1,227 @@
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+type (
+	// limits a pause to a garage door or a tracker; a pause without a garage door or tracker pauses all garage doors
+	PauseScope struct {
+		Door    string `json:"door,omitempty"`
+		Tracker string `json:"tracker,omitempty"` // pauses actions triggered by trackers with this id at every garage door
+	}
+
+	// a user-requested pause of garage operations in effect
+	PauseStatus struct {
+		PauseScope
+		Until     time.Time `json:"until,omitzero"`      // zero for an indefinite pause
+		Remaining int       `json:"remaining,omitempty"` // seconds remaining, for finite pauses
+	}
+
+	pause struct {
+		until time.Time   // zero for an indefinite pause
+		timer *time.Timer // resumes a finite pause once it times out
+	}
+
+	// a pause or resume command, e.g. received over mqtt
+	PauseCommand struct {
+		Command  string `json:"command"`  // `pause` or `resume`
+		Duration int    `json:"duration"` // seconds to pause for; pauses indefinitely if omitted
+		PauseScope
+	}
+)
+
+const (
+	CommandPause  = "pause"
+	CommandResume = "resume"
+)
+
+var (
+	pauses       = map[PauseScope]*pause{}
+	pausesLock   sync.Mutex          // guards pauses and pauseChanged
+	pauseChanged func([]PauseStatus) // optional, called with the pauses in effect whenever they change
+)
+
+func (s PauseScope) String() string {
+	switch {
+	case s.Door != "":
+		return "garage door " + s.Door
+	case s.Tracker != "":
+		return "tracker " + s.Tracker
+	}
+	return "all garage doors"
+}
+
+// checks that the scope's garage door or tracker exists
+func (s PauseScope) validate() error {
+	if s.Door != "" && s.Tracker != "" {
+		return errors.New("a pause may be limited to a garage door or a tracker, not both")
+	}
+	if s.Door != "" && FindGarageDoor(s.Door) == nil {
+		return fmt.Errorf("garage door %s not found", s.Door)
+	}
+	if s.Tracker != "" && len(TrackerStatuses("", s.Tracker)) == 0 {
+		return fmt.Errorf("tracker %s not found", s.Tracker)
+	}
+	return nil
+}
+
+// pauses garage operations within the scope for the duration, or indefinitely if the duration isn't positive,
+// replacing any pause already in effect for the scope (e.g. to extend it)
+// all other processing still functions (e.g. tracking, geofence awareness, etc), only garage operations are disabled
+func Pause(scope PauseScope, duration time.Duration) error {
+	if err := scope.validate(); err != nil {
+		return err
+	}
+	defer notifyPauseChanged()
+	pausesLock.Lock()
+	defer pausesLock.Unlock()
+	if p, ok := pauses[scope]; ok && p.timer != nil {
+		p.timer.Stop()
+	}
+
+	p := &pause{}
+	if duration > 0 {
+		logger.Infof("Pausing operations for %s for %s; use the /resume endpoint to resume garage operations sooner", scope, duration)
+		p.until = time.Now().Add(duration)
+		p.timer = time.AfterFunc(duration, func() {
+			pausesLock.Lock()
+			expired := pauses[scope] == p // unless it was since replaced or resumed
+			if expired {
+				logger.Infof("Pause timeout reached; resuming operations for %s", scope)
+				delete(pauses, scope)
+			}
+			pausesLock.Unlock()
+			if expired {
+				notifyPauseChanged()
+			}
+		})
+	} else {
+		logger.Infof("Pausing operations for %s indefinitely; use the /resume endpoint to resume garage operations", scope)
+	}
+	pauses[scope] = p
+	return nil
+}
+
+// resumes garage operations within the scope; resuming all garage doors also resumes any pauses limited to a garage
+// door or tracker, whereas resuming a garage door or tracker doesn't override a pause of all garage doors
+func Resume(scope PauseScope) error {
+	if err := scope.validate(); err != nil {
+		return err
+	}
+	logger.Infof("Resuming operations for %s", scope)
+	defer notifyPauseChanged()
+	pausesLock.Lock()
+	defer pausesLock.Unlock()
+	for s, p := range pauses {
+		if s != scope && scope != (PauseScope{}) {
+			continue
+		}
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+		delete(pauses, s)
+	}
+	return nil
+}
+
+// sets the function called with the pauses in effect whenever they change, e.g. to publish them; nil removes it
+func OnPauseChanged(f func([]PauseStatus)) {
+	pausesLock.Lock()
+	defer pausesLock.Unlock()
+	pauseChanged = f
+}
+
+// calls the function set with OnPauseChanged with the pauses in effect, if any; must be called without the lock held
+func notifyPauseChanged() {
+	pausesLock.Lock()
+	changed := pauseChanged
+	pausesLock.Unlock()
+	if changed != nil {
+		changed(Pauses())
+	}
+}
+
+// parses a pause or resume command from a json payload, e.g. `{"command": "pause", "duration": 600, "door": "side"}`,
+// or from a plain `pause` or `resume` payload for all garage doors
+func ParsePauseCommand(payload []byte) (PauseCommand, error) {
+	var c PauseCommand
+	trimmed := strings.TrimSpace(string(payload))
+	if strings.HasPrefix(trimmed, "{") {
+		if err := json.Unmarshal([]byte(trimmed), &c); err != nil {
+			return c, fmt.Errorf("could not unmarshal json payload, received error: %v", err)
+		}
+	} else {
+		c.Command = trimmed
+	}
+	c.Command = strings.ToLower(c.Command)
+	if c.Command != CommandPause && c.Command != CommandResume {
+		return c, fmt.Errorf("command must be `%s` or `%s`, found '%s'", CommandPause, CommandResume, c.Command)
+	}
+	return c, nil
+}
+
+// pauses or resumes garage operations per the command
+func (c PauseCommand) Execute() error {
+	if c.Command == CommandResume {
+		return Resume(c.PauseScope)
+	}
+	return Pause(c.PauseScope, time.Duration(c.Duration)*time.Second)
+}
+
+// returns the pauses in effect, ordered by scope
+func Pauses() []PauseStatus {
+	pausesLock.Lock()
+	defer pausesLock.Unlock()
+	statuses := []PauseStatus{}
+	for s, p := range pauses {
+		statuses = append(statuses, p.status(s))
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Door != statuses[j].Door {
+			return statuses[i].Door < statuses[j].Door
+		}
+		return statuses[i].Tracker < statuses[j].Tracker
+	})
+	return statuses
+}
+
+func (p *pause) status(scope PauseScope) PauseStatus {
+	s := PauseStatus{PauseScope: scope, Until: p.until}
+	if !p.until.IsZero() {
+		s.Remaining = int(math.Ceil(time.Until(p.until).Seconds()))
+	}
+	return s
+}
+
+// returns the first pause in effect for any of the scopes
+func pausedBy(scopes ...PauseScope) *PauseStatus {
+	pausesLock.Lock()
+	defer pausesLock.Unlock()
+	for _, s := range scopes {
+		if p, ok := pauses[s]; ok {
+			status := p.status(s)
+			return &status
+		}
+	}
+	return nil
+}
+
+// returns the pause in effect for the garage door, if any
+func (g *GarageDoor) pause() *PauseStatus {
+	return pausedBy(PauseScope{}, PauseScope{Door: g.ID})
+}
+
+// returns the pause in effect for actions triggered by the tracker, if any
+func (t *Tracker) pause() *PauseStatus {
+	return pausedBy(PauseScope{}, PauseScope{Door: t.GarageDoor.ID}, PauseScope{Tracker: fmt.Sprintf("%v", t.ID)})
+}
@@ -0,0 +1,185 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	util "github.com/brchri/tesla-geogdo/internal/util"
+	logger "github.com/sirupsen/logrus"
+)
+
+type (
+	// runtime state persisted across restarts
+	stateSnapshot struct {
+		SavedAt     time.Time            `json:"saved_at"`
+		Pauses      []PauseStatus        `json:"pauses"`
+		GarageDoors []garageDoorSnapshot `json:"garage_doors"`
+	}
+
+	garageDoorSnapshot struct {
+		ID            string            `json:"id"`
+		LastOperation time.Time         `json:"last_operation"`
+		Trackers      []trackerSnapshot `json:"trackers"`
+	}
+
+	trackerSnapshot struct {
+		ID                      string    `json:"id"`
+		CurrentLocation         Point     `json:"current_location"`
+		CurDistance             float64   `json:"cur_distance"`
+		PrevGeofence            string    `json:"prev_geofence"`
+		CurGeofence             string    `json:"cur_geofence"`
+		InsidePolyOpenGeo       bool      `json:"inside_poly_open_geo"`
+		InsidePolyCloseGeo      bool      `json:"inside_poly_close_geo"`
+		InsidePolyRestrictedGeo bool      `json:"inside_poly_restricted_geo"`
+		LastEnteredCloseGeo     time.Time `json:"last_entered_close_geo"`
+		LastLeftOpenGeo         time.Time `json:"last_left_open_geo"`
+		Initialized             bool      `json:"initialized"`
+	}
+)
+
+// writes the trackers' geofence membership, the garage doors' last operations, and any pauses in effect to the file;
+// the file is replaced atomically so a crash while saving doesn't corrupt the previous state
+func SaveState(path string) error {
+	snapshot := stateSnapshot{
+		SavedAt: time.Now(),
+		Pauses:  Pauses(),
+	}
+	for _, g := range GarageDoors {
+		g.statusLock.Lock()
+		gs := garageDoorSnapshot{ID: g.ID, LastOperation: g.LastOperation}
+		g.statusLock.Unlock()
+		g.evalLock.Lock() // snapshot the trackers between evaluations
+		for _, t := range g.Trackers {
+			gs.Trackers = append(gs.Trackers, trackerSnapshot{
+				ID:                      fmt.Sprintf("%v", t.ID),
+				CurrentLocation:         t.CurrentLocation,
+				CurDistance:             t.CurDistance,
+				PrevGeofence:            t.PrevGeofence,
+				CurGeofence:             t.CurGeofence,
+				InsidePolyOpenGeo:       t.InsidePolyOpenGeo,
+				InsidePolyCloseGeo:      t.InsidePolyCloseGeo,
+				InsidePolyRestrictedGeo: t.InsidePolyRestrictedGeo,
+				LastEnteredCloseGeo:     t.LastEnteredCloseGeo,
+				LastLeftOpenGeo:         t.LastLeftOpenGeo,
+				Initialized:             t.initialized || t.restored,
+			})
+		}
+		g.evalLock.Unlock()
+		snapshot.GarageDoors = append(snapshot.GarageDoors, gs)
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal state, received error: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create state file, received error: %v", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write state file, received error: %v", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write state file, received error: %v", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to write state file, received error: %v", err)
+	}
+	return nil
+}
+
+// restores the state saved by SaveState, unless it's older than maxAge; garage doors and trackers are matched by
+// their ids, and anything that no longer matches the config is discarded
+func RestoreState(path string, maxAge time.Duration) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		logger.Debugf("No state file found at %s, starting without persisted state", path)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to read state file %s, received error: %v", path, err)
+	}
+	var snapshot stateSnapshot
+	if err = json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("unable to parse state file %s, received error: %v", path, err)
+	}
+	age := time.Since(snapshot.SavedAt)
+	if age > maxAge {
+		logger.Infof("Persisted state was saved %s ago, which exceeds the maximum age of %s; discarding it", age.Round(time.Second), maxAge)
+		return nil
+	}
+
+	for _, gs := range snapshot.GarageDoors {
+		g := FindGarageDoor(gs.ID)
+		if g == nil {
+			continue
+		}
+		g.LastOperation = gs.LastOperation
+		g.restoreCooldown()
+		for _, ts := range gs.Trackers {
+			for _, t := range g.Trackers {
+				if fmt.Sprintf("%v", t.ID) != ts.ID {
+					continue
+				}
+				t.CurrentLocation = ts.CurrentLocation
+				t.CurDistance = ts.CurDistance
+				t.PrevGeofence = ts.PrevGeofence
+				t.CurGeofence = ts.CurGeofence
+				t.InsidePolyOpenGeo = ts.InsidePolyOpenGeo
+				t.InsidePolyCloseGeo = ts.InsidePolyCloseGeo
+				t.InsidePolyRestrictedGeo = ts.InsidePolyRestrictedGeo
+				t.LastEnteredCloseGeo = ts.LastEnteredCloseGeo
+				t.LastLeftOpenGeo = ts.LastLeftOpenGeo
+				// the tracker may have moved while we were stopped, so its first update still only seeds its membership
+				t.initialized = false
+				t.restored = ts.Initialized
+				logger.Debugf("Restored persisted state for tracker %v", t.ID)
+			}
+		}
+	}
+
+	// finite pauses continued counting down while we were stopped
+	for _, p := range snapshot.Pauses {
+		var duration time.Duration
+		if !p.Until.IsZero() {
+			if duration = time.Until(p.Until); duration <= 0 {
+				continue
+			}
+		}
+		logger.Infof("Restoring pause of garage operations for %s from before restart", p.PauseScope)
+		if err := Pause(p.PauseScope, duration); err != nil {
+			logger.Warnf("Unable to restore pause of garage operations, received error: %v", err)
+		}
+	}
+	logger.Infof("Restored persisted state saved %s ago", age.Round(time.Second))
+	return nil
+}
+
+// locks the garage door for the remainder of its cooldown if it was operated shortly before the state was saved
+func (g *GarageDoor) restoreCooldown() {
+	cooldown := time.Duration(util.Config.Global.OpCooldown) * time.Minute
+	remaining := time.Until(g.LastOperation.Add(cooldown))
+	if cooldown <= 0 || remaining <= 0 {
+		return
+	}
+	logger.Infof("Garage door %s was operated at %s, locking operations for the remaining %s of the cooldown", g, g.LastOperation.Format("15:04:05"), remaining.Round(time.Second))
+	g.OpLock.Store(true)
+	g.setCooldownUntil(time.Now().Add(remaining))
+	go func() {
+		time.Sleep(remaining)
+		g.OpLock.Store(false)
+	}()
+}
+
+// periodically saves state to the file so it survives an unclean shutdown
+func PersistState(path string, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := SaveState(path); err != nil {
+			logger.Warn(err)
+		}
+	}
+}
@@ -14,29 +14,45 @@ import (
 )
 
 type (
-	// contains 3 geofences, open, close, and restricted, each of which are a list of lat/long points defining the polygon
+	// contains 3 geofences, open, close, and restricted, each of which are a list of polygons
 	PolygonGeofence struct {
-		Close      []Point `yaml:"close,omitempty"`      // list of points defining a polygon; when vehicle moves from inside this geofence to outside, garage will close
-		Open       []Point `yaml:"open,omitempty"`       // list of points defining a polygon; when vehicle moves from outside this geofence to inside, garage will open
-		Restricted []Point `yaml:"restricted,omitempty"` // list of points defining a polygon; when vehicle moves from inside this geofence to inside open geofence, garage will not open
-		KMLFile    string  `yaml:"kml_file,omitempty"`
+		Close       Polygons     `yaml:"close,omitempty"`        // polygons defining the close geofence; when vehicle moves from inside this geofence to outside, garage will close
+		Open        Polygons     `yaml:"open,omitempty"`         // polygons defining the open geofence; when vehicle moves from outside this geofence to inside, garage will open
+		Restricted  Polygons     `yaml:"restricted,omitempty"`   // polygons defining restricted zones; when vehicle moves from inside any of these to inside open geofence, garage will not open
+		OpenHeading HeadingRange `yaml:"open_heading,omitempty"` // optional, only open if the tracker's heading is within this range when entering the open geofence
+		OpenETA     int          `yaml:"open_eta,omitempty"`     // optional, seconds; open when an approaching tracker is estimated to reach the open geofence within this time based on its speed
+		KMLFile     string       `yaml:"kml_file,omitempty"`
+		GeoJSONFile string       `yaml:"geojson_file,omitempty"`
 	}
 
+	// a polygon defined by an outer boundary and optional holes; a point within a hole is considered outside the polygon
+	Polygon struct {
+		Outer []Point   `yaml:"outer"`
+		Holes [][]Point `yaml:"holes,omitempty"`
+	}
+
+	// list of polygons making up a single geofence; a point within any of the polygons is considered inside the geofence
+	// can be defined in yaml as a list of points for a single polygon without holes, or as a list of polygons
+	Polygons []Polygon
+
 	// kml schema to parse coordinates from kml file for polygon geofences
 	KML struct {
 		Document struct {
 			Placemarks []struct {
 				Name    string `xml:"name"`
 				Polygon struct {
-					OuterBoundary struct {
-						LinearRing struct {
-							Coordinates string `xml:"coordinates"`
-						} `xml:"linearring"`
-					} `xml:"outerboundaryis"`
+					OuterBoundary kmlBoundary   `xml:"outerboundaryis"`
+					InnerBoundary []kmlBoundary `xml:"innerboundaryis"`
 				} `xml:"polygon"`
 			} `xml:"placemark"`
 		} `xml:"document"`
 	}
+
+	kmlBoundary struct {
+		LinearRing struct {
+			Coordinates string `xml:"coordinates"`
+		} `xml:"linearring"`
+	}
 )
 
 func init() {
@@ -47,19 +63,83 @@ func init() {
 	}
 }
 
+// accepts either a list of points (a single polygon without holes, for compatibility with
+// configs predating polygon lists) or a list of polygons
+func (ps *Polygons) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode && len(value.Content) > 0 && isPointNode(value.Content[0]) {
+		var points []Point
+		if err := value.Decode(&points); err != nil {
+			return err
+		}
+		*ps = Polygons{{Outer: points}}
+		return nil
+	}
+	var polygons []Polygon
+	if err := value.Decode(&polygons); err != nil {
+		return err
+	}
+	*ps = polygons
+	return nil
+}
+
+// marshals a single polygon without holes as a list of points to keep simple configs simple
+func (ps Polygons) MarshalYAML() (interface{}, error) {
+	if len(ps) == 1 && len(ps[0].Holes) == 0 {
+		return ps[0].Outer, nil
+	}
+	return []Polygon(ps), nil
+}
+
+// indicates whether a yaml node is a mapping with a lat or lng key
+func isPointNode(node *yaml.Node) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if key := node.Content[i].Value; key == "lat" || key == "lng" {
+			return true
+		}
+	}
+	return false
+}
+
+// indicates whether the point is inside any of the polygons
+func (ps Polygons) contains(p Point) bool {
+	for _, polygon := range ps {
+		if polygon.contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// indicates whether the point is inside the polygon's outer boundary and not inside any of its holes
+func (pg Polygon) contains(p Point) bool {
+	if !isInsidePolygonGeo(p, pg.Outer) {
+		return false
+	}
+	for _, hole := range pg.Holes {
+		if isInsidePolygonGeo(p, hole) {
+			return false
+		}
+	}
+	return true
+}
+
 // get action based on whether we had a polygon geofence change event
-// uses ray-casting algorithm, assumes a simple geofence (no holes or border cross points)
+// uses ray-casting algorithm, assumes simple polygon boundaries (no border cross points)
+func (p *PolygonGeofence) isSeeded(tracker *Tracker) bool {
+	return tracker.CurrentLocation.IsPointDefined()
+}
+
 func (p *PolygonGeofence) getEventChangeAction(tracker *Tracker) (action string) {
 	if !tracker.CurrentLocation.IsPointDefined() {
 		return // need valid lat and long to check geofence
 	}
 
-	isInsideCloseGeo := isInsidePolygonGeo(tracker.CurrentLocation, p.Close)
-	isInsideOpenGeo := isInsidePolygonGeo(tracker.CurrentLocation, p.Open)
-	isInsideRestrictedGeo := false
-	if len(p.Restricted) > 0 {
-		isInsideRestrictedGeo = isInsidePolygonGeo(tracker.CurrentLocation, p.Restricted)
-	}
+	isInsideCloseGeo := p.Close.contains(tracker.CurrentLocation)
+	isInsideOpenGeo := p.Open.contains(tracker.CurrentLocation)
+	isInsideRestrictedGeo := p.Restricted.contains(tracker.CurrentLocation)
 
 	if len(p.Close) > 0 {
 		if tracker.InsidePolyCloseGeo && !tracker.InsidePolyRestrictedGeo && !isInsideCloseGeo { // if we were inside the close geofence and now we're not, then close (if also not coming from a restricted zone)
@@ -70,10 +150,27 @@ func (p *PolygonGeofence) getEventChangeAction(tracker *Tracker) (action string)
 	}
 	if len(p.Open) > 0 {
 		if !tracker.InsidePolyOpenGeo && !tracker.InsidePolyRestrictedGeo && isInsideOpenGeo { // if we were not inside the open geo or the restricted geo, and now we are in the open geo, then open
-			action = ActionOpen
+			openedByETA := tracker.OpenedByETA
+			tracker.WithinOpenETA, tracker.OpenedByETA = false, false
+			if openedByETA {
+				logger.Debugf("Tracker %v entered the open geofence, but the garage was already opened by its estimated arrival", tracker.ID)
+			} else if p.OpenHeading.allowsOpen(tracker) {
+				action = ActionOpen
+			}
 		} else if tracker.InsidePolyOpenGeo && !isInsideOpenGeo { // if we just left the open geo, then set LastNoOpEvent to prevent flapping and accidentally triggering an open
 			tracker.LastLeftOpenGeo = time.Now()
 		}
+		// if still outside the open geo and not in a restricted zone, check if the tracker will arrive within the open eta
+		if p.OpenETA > 0 && !isInsideOpenGeo && !isInsideRestrictedGeo && !tracker.InsidePolyRestrictedGeo {
+			prevDistance := tracker.OpenGeoDistance
+			tracker.OpenGeoDistance = p.Open.distanceTo(tracker.CurrentLocation)
+			if tracker.enteredOpenETA(p.OpenETA, tracker.OpenGeoDistance, prevDistance > 0 && tracker.OpenGeoDistance < prevDistance) &&
+				p.OpenHeading.allowsOpen(tracker) {
+				action = ActionOpen
+			}
+		} else {
+			tracker.OpenGeoDistance = 0
+		}
 	}
 
 	tracker.InsidePolyCloseGeo = isInsideCloseGeo
@@ -83,6 +180,20 @@ func (p *PolygonGeofence) getEventChangeAction(tracker *Tracker) (action string)
 	return
 }
 
+func (p *PolygonGeofence) isOnActionSide(tracker *Tracker, action string) bool {
+	if action == ActionOpen {
+		return len(p.Open) > 0 && tracker.InsidePolyOpenGeo
+	}
+	return len(p.Close) > 0 && !tracker.InsidePolyCloseGeo
+}
+
+func (p *PolygonGeofence) isHome(tracker *Tracker) bool {
+	if len(p.Close) > 0 {
+		return tracker.InsidePolyCloseGeo
+	}
+	return len(p.Open) > 0 && tracker.InsidePolyOpenGeo
+}
+
 func isInsidePolygonGeo(p Point, geofence []Point) bool {
 	var intersections int
 	j := len(geofence) - 1
@@ -114,51 +225,80 @@ func loadKMLFile(p *PolygonGeofence) error {
 		return err
 	}
 
+	// placemarks from the kml file replace any inline polygons; multiple placemarks with the same name are combined
+	var open, close, restricted Polygons
+
 	// loop through placemarks to get name and, if relevant, parse the coordinates accordingly
 	for _, placemark := range kml.Document.Placemarks {
-		var polygonGeoPoints []Point
-		// geofences must be named `open` or `close` or they're considered irrelevant
+		// geofences must be named `open`, `close`, or `restricted` or they're considered irrelevant
 		if placemark.Name != "open" && placemark.Name != "close" && placemark.Name != "restricted" {
 			continue
 		}
 
-		for _, c := range strings.Split(placemark.Polygon.OuterBoundary.LinearRing.Coordinates, "\n") {
-			// trim whitespace and continue loop if nothing is left
-			c = strings.TrimSpace(c)
-			if c == "" {
-				continue
-			}
-
-			// kml coordinate format is longitude,latitude; split comma delim and parse coords
-			coords := strings.Split(c, ",")
-			lat, err := strconv.ParseFloat(coords[1], 64)
-			if err != nil {
-				logger.Infof("Could not parse lng/lat coordinates from line %s, received error: %v", c, err)
-				return err
-			}
-			lng, err := strconv.ParseFloat(coords[0], 64)
+		var polygon Polygon
+		polygon.Outer, err = parseKMLCoordinates(placemark.Polygon.OuterBoundary.LinearRing.Coordinates)
+		if err != nil {
+			return err
+		}
+		for _, inner := range placemark.Polygon.InnerBoundary {
+			hole, err := parseKMLCoordinates(inner.LinearRing.Coordinates)
 			if err != nil {
-				logger.Infof("Could not parse lng/lat coordinates from line %s, received error: %v", c, err)
 				return err
 			}
-
-			polygonGeoPoints = append(polygonGeoPoints, Point{Lat: lat, Lng: lng})
+			polygon.Holes = append(polygon.Holes, hole)
 		}
 
-		// set either open or close polygon geo for garage door based on Placemark's Name element
+		// add polygon to either open, close, or restricted geo for garage door based on Placemark's Name element
 		switch placemark.Name {
 		case "open":
-			p.Open = polygonGeoPoints
+			open = append(open, polygon)
 		case "close":
-			p.Close = polygonGeoPoints
+			close = append(close, polygon)
 		case "restricted":
-			p.Restricted = polygonGeoPoints
+			restricted = append(restricted, polygon)
 		}
 	}
 
+	if len(open) > 0 {
+		p.Open = open
+	}
+	if len(close) > 0 {
+		p.Close = close
+	}
+	if len(restricted) > 0 {
+		p.Restricted = restricted
+	}
+
 	return nil
 }
 
+// parses a kml coordinates element into a list of points
+func parseKMLCoordinates(coordinates string) ([]Point, error) {
+	var points []Point
+	// kml coordinate tuples are separated by whitespace
+	for _, c := range strings.Fields(coordinates) {
+		// kml coordinate format is longitude,latitude[,altitude]; split comma delim and parse coords
+		coords := strings.Split(c, ",")
+		if len(coords) < 2 {
+			logger.Infof("Could not parse lng/lat coordinates from %s", c)
+			return nil, fmt.Errorf("could not parse lng/lat coordinates from %s", c)
+		}
+		lat, err := strconv.ParseFloat(coords[1], 64)
+		if err != nil {
+			logger.Infof("Could not parse lng/lat coordinates from line %s, received error: %v", c, err)
+			return nil, err
+		}
+		lng, err := strconv.ParseFloat(coords[0], 64)
+		if err != nil {
+			logger.Infof("Could not parse lng/lat coordinates from line %s, received error: %v", c, err)
+			return nil, err
+		}
+
+		points = append(points, Point{Lat: lat, Lng: lng})
+	}
+	return points, nil
+}
+
 func (p *PolygonGeofence) parseSettings(config map[string]interface{}) error {
 	yamlData, err := yaml.Marshal(config)
 	var settings PolygonGeofence
@@ -170,8 +310,14 @@ func (p *PolygonGeofence) parseSettings(config map[string]interface{}) error {
 		return fmt.Errorf("failed to unmarshal geofence yaml object, error: %v", err)
 	}
 	*p = settings
+	if p.KMLFile != "" && p.GeoJSONFile != "" {
+		return fmt.Errorf("only one of kml_file or geojson_file may be defined")
+	}
 	if p.KMLFile != "" {
 		return loadKMLFile(p)
 	}
+	if p.GeoJSONFile != "" {
+		return loadGeoJSONFile(p)
+	}
 	return nil
 }
@@ -0,0 +1,143 @@
+package geo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type (
+	// defines when a garage door may be operated automatically; if windows are defined for an action,
+	// the action will only be executed within one of them
+	ScheduleSettings struct {
+		Timezone string           `yaml:"timezone"` // optional, IANA timezone for the windows, e.g. America/New_York; defaults to the container's timezone (TZ)
+		Open     []ScheduleWindow `yaml:"open"`     // optional, windows when the garage may be opened
+		Close    []ScheduleWindow `yaml:"close"`    // optional, windows when the garage may be closed
+		Calendar CalendarSettings `yaml:"calendar"` // optional, ics calendar whose events suppress actions for their duration
+		location *time.Location
+	}
+
+	// defines a recurring window of time; if `to` is earlier than `from`, the window ends on the following day
+	ScheduleWindow struct {
+		Days []string `yaml:"days"` // optional, days the window starts on, e.g. mon, tuesday, weekdays, or weekends; defaults to every day
+		From string   `yaml:"from"` // start time in 24 hour format, e.g. 06:00
+		To   string   `yaml:"to"`   // end time in 24 hour format, e.g. 22:30
+		days map[time.Weekday]bool
+		from int // minutes since midnight
+		to   int // minutes since midnight
+	}
+)
+
+var scheduleDays = map[string][]time.Weekday{
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+// validates the schedule and parses its timezone, days, and times
+func (s *ScheduleSettings) parse() error {
+	s.location = time.Local
+	if s.Timezone != "" {
+		var err error
+		if s.location, err = time.LoadLocation(s.Timezone); err != nil {
+			return fmt.Errorf("invalid schedule timezone %s, received error: %v", s.Timezone, err)
+		}
+	}
+	for _, windows := range [][]ScheduleWindow{s.Open, s.Close} {
+		for i := range windows {
+			if err := windows[i].parse(); err != nil {
+				return err
+			}
+		}
+	}
+	return s.Calendar.parse(s.location)
+}
+
+func (w *ScheduleWindow) parse() error {
+	var err error
+	if w.from, err = parseScheduleTime(w.From); err != nil {
+		return err
+	}
+	if w.to, err = parseScheduleTime(w.To); err != nil {
+		return err
+	}
+	if len(w.Days) == 0 {
+		w.Days = []string{"weekdays", "weekends"}
+	}
+	w.days = map[time.Weekday]bool{}
+	for _, day := range w.Days {
+		d := strings.ToLower(day)
+		for wd := time.Sunday; wd <= time.Saturday; wd++ {
+			if d == strings.ToLower(wd.String()) {
+				d = d[:3] // accept full day names, e.g. monday
+			}
+		}
+		days, ok := scheduleDays[d]
+		if !ok {
+			return fmt.Errorf("invalid schedule day %s", day)
+		}
+		for _, day := range days {
+			w.days[day] = true
+		}
+	}
+	return nil
+}
+
+// parses a time in 24 hour format to minutes since midnight
+func parseScheduleTime(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schedule time '%s', must be in 24 hour format, e.g. 06:00 or 22:30", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// indicates whether the time falls within the window
+func (w ScheduleWindow) contains(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+	if w.from == w.to { // window spans the whole day
+		return w.days[t.Weekday()]
+	}
+	if w.from < w.to {
+		return w.days[t.Weekday()] && minutes >= w.from && minutes < w.to
+	}
+	// window ends the following day, so the time is either after the start today or before the end of a window that started yesterday
+	return (w.days[t.Weekday()] && minutes >= w.from) || (w.days[t.AddDate(0, 0, -1).Weekday()] && minutes < w.to)
+}
+
+// returns the calendar events suppressing actions at the given time
+func (s *ScheduleSettings) calendarSuppressions(t time.Time) []CalendarStatus {
+	if s.location != nil {
+		t = t.In(s.location)
+	}
+	return s.Calendar.suppressions(t, t.Location())
+}
+
+// indicates whether the action may be executed at the given time, and if not, the reason why
+func (s *ScheduleSettings) allows(action string, t time.Time) (bool, string) {
+	if s.location != nil {
+		t = t.In(s.location)
+	}
+	if allowed, reason := s.Calendar.allows(action, t, t.Location()); !allowed {
+		return false, reason
+	}
+	windows := s.Open
+	if action == ActionClose {
+		windows = s.Close
+	}
+	if len(windows) == 0 {
+		return true, ""
+	}
+	for _, w := range windows {
+		if w.contains(t) {
+			return true, ""
+		}
+	}
+	return false, fmt.Sprintf("current time %s is outside of the garage door's %s schedule", t.Format("Mon 15:04 MST"), action)
+}
@@ -0,0 +1,88 @@
+package geo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/brchri/tesla-geogdo/internal/gdo/state"
+	logger "github.com/sirupsen/logrus"
+)
+
+type (
+	// defines how a garage door is reconciled with its trackers' locations when the service starts
+	StartupSettings struct {
+		Reconcile string `yaml:"reconcile,omitempty"` // optional, `close` or `alert`; action to take if the door is open once every tracker has reported and none are home
+	}
+)
+
+const (
+	ReconcileClose = "close"
+	ReconcileAlert = "alert"
+)
+
+func (s StartupSettings) validate() error {
+	switch s.Reconcile {
+	case "", ReconcileClose, ReconcileAlert:
+		return nil
+	}
+	return fmt.Errorf("startup reconcile must be `%s` or `%s`, found '%s'", ReconcileClose, ReconcileAlert, s.Reconcile)
+}
+
+// seeds the tracker's geofence membership from its first location or state without executing any actions,
+// so a restart doesn't act on the tracker's assumed position from before its first update; if its membership was
+// restored from persisted state, a change since then is logged, and left to the garage door's startup reconcile
+// composite geofences may need several updates, e.g. both a location and a state, before every child is seeded
+func (t *Tracker) initialize() {
+	wasHome := t.GarageDoor.Geofence.isHome(t)
+	if action := t.GarageDoor.Geofence.getEventChangeAction(t); action != "" {
+		logger.Debugf("Ignoring action '%s' for tracker %v while initializing its geofence membership", action, t.ID)
+	}
+	if !t.GarageDoor.Geofence.isSeeded(t) {
+		logger.Debugf("Tracker %v hasn't reported for every geofence yet, waiting for its next update to finish initializing", t.ID)
+		return
+	}
+	if isHome := t.GarageDoor.Geofence.isHome(t); t.restored && isHome != wasHome {
+		logger.Infof("Tracker %v was home: %t before the restart, but is now home: %t; not acting on the change", t.ID, wasHome, isHome)
+	}
+	t.restored = false
+	// boundary crossings from the assumed initial position aren't real, so don't let them suppress later actions
+	t.LastEnteredCloseGeo = time.Time{}
+	t.LastLeftOpenGeo = time.Time{}
+	t.WithinOpenETA, t.OpenedByETA = false, false
+	t.initialized = true
+	logger.Infof("Initialized tracker %v, home: %t", t.ID, t.GarageDoor.Geofence.isHome(t))
+}
+
+// once every tracker for the garage door has been initialized, checks whether the door was left open while
+// every tracker is away (e.g. a car left while the service was down), and closes it or logs a warning per
+// the garage door's startup settings; only checked once
+// a close is executed as the tracker's action, so it's recorded in the tracker's decision
+func (g *GarageDoor) reconcile(tracker *Tracker, d *Decision) {
+	if g.Startup.Reconcile == "" {
+		return
+	}
+	for _, t := range g.Trackers {
+		if !t.initialized {
+			return // wait for the rest of the trackers
+		}
+	}
+	g.reconcileOnce.Do(func() {
+		if occupants := g.occupants(nil); len(occupants) > 0 {
+			logger.Debugf("Tracker(s) %v are home, garage door %s doesn't need to be reconciled", occupants, g)
+			return
+		}
+		doorState := g.Opener.Status().State
+		if doorState != state.Open {
+			logger.Debugf("Garage door %s state is %s and no trackers are home, garage door doesn't need to be reconciled", g, doorState)
+			return
+		}
+		if g.Startup.Reconcile == ReconcileAlert {
+			logger.Warnf("Garage door %s is open, but none of its trackers are home", g)
+			return
+		}
+		logger.Infof("Garage door %s is open, but none of its trackers are home; closing it", g)
+		d.BlockedBy, d.Reason = "", ""
+		d.Action = ActionClose
+		executeAction(tracker, ActionClose, d)
+	})
+}
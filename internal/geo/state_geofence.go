@@ -0,0 +1,223 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// defines triggers for open and close actions based on a state published to an mqtt topic, such as
+	// a teslamate geofence name, a home assistant person or zone state, or an owntracks region
+	StateGeofence struct {
+		Topic    string       `yaml:"topic,omitempty"`         // optional, topic to watch for trackers that don't define their own geofence_topic
+		JSONPath string       `yaml:"json_path,omitempty"`     // optional, dot-separated path to extract the state from a json payload, e.g. attributes.zone or inregions.0
+		Regex    bool         `yaml:"regex,omitempty"`         // optional, treat trigger values as regular expressions that must match the entire state
+		Close    StateTrigger `yaml:"close_trigger,omitempty"` // garage will close when tracker moves from `from` to `to`
+		Open     StateTrigger `yaml:"open_trigger,omitempty"`  // garage will open when tracker moves from `from` to `to`
+
+		requireFrom bool // triggers without a `from` are ignored, as they always have been for the teslamate preset
+	}
+
+	// defines which state change will trigger an event, e.g. "home" to "not_home"
+	// if `from` is omitted, a change from any known state to `to` will trigger an event
+	StateTrigger struct {
+		From StateValues `yaml:"from,omitempty"`
+		To   StateValues `yaml:"to,omitempty"`
+
+		fromPatterns, toPatterns []*regexp.Regexp // From and To compiled in parseSettings, when regex is enabled
+	}
+
+	// list of states; can be defined in yaml as a single value or a list of values
+	StateValues []string
+
+	// teslamate geofences are state geofences watching the tracker's geofence_topic for exact geofence names
+	TeslamateGeofence        = StateGeofence
+	TeslamateGeofenceTrigger = StateTrigger
+)
+
+// accepts either a single value or a list of values
+func (v *StateValues) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*v = StateValues{value.Value}
+		return nil
+	}
+	var values []string
+	if err := value.Decode(&values); err != nil {
+		return err
+	}
+	*v = values
+	return nil
+}
+
+// marshals a single value as a scalar to keep simple configs simple
+func (v StateValues) MarshalYAML() (interface{}, error) {
+	if len(v) == 1 {
+		return v[0], nil
+	}
+	return []string(v), nil
+}
+
+// gets action based on if there was a relevant state change
+func (s *StateGeofence) getEventChangeAction(tracker *Tracker) (action string) {
+	if s.isTriggered(s.Close, tracker) {
+		action = ActionClose
+	} else if s.isTriggered(s.Open, tracker) {
+		action = ActionOpen
+	}
+	return
+}
+
+// indicates whether the tracker's state change matches the trigger
+func (s *StateGeofence) isTriggered(trigger StateTrigger, tracker *Tracker) bool {
+	if !s.isDefined(trigger) || tracker.PrevGeofence == tracker.CurGeofence {
+		return false
+	}
+	if len(trigger.From) == 0 {
+		// without a `from`, any known previous state will do; an unknown previous state
+		// means this is the first state received, which isn't a change
+		return tracker.PrevGeofence != "" && s.matches(trigger.To, trigger.toPatterns, tracker.CurGeofence)
+	}
+	return s.matches(trigger.From, trigger.fromPatterns, tracker.PrevGeofence) && s.matches(trigger.To, trigger.toPatterns, tracker.CurGeofence)
+}
+
+// indicates whether the state matches any of the values exactly, or any of their compiled patterns if regex is enabled
+func (s *StateGeofence) matches(values StateValues, patterns []*regexp.Regexp, state string) bool {
+	if s.Regex {
+		for _, p := range patterns {
+			if p.MatchString(state) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, v := range values {
+		if v == state {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *StateGeofence) isOnActionSide(tracker *Tracker, action string) bool {
+	if action == ActionOpen {
+		return s.isDefined(s.Open) && s.matches(s.Open.To, s.Open.toPatterns, tracker.CurGeofence)
+	}
+	return s.isDefined(s.Close) && s.matches(s.Close.To, s.Close.toPatterns, tracker.CurGeofence)
+}
+
+func (s *StateGeofence) isHome(tracker *Tracker) bool {
+	if s.isDefined(s.Close) && len(s.Close.From) > 0 {
+		return s.matches(s.Close.From, s.Close.fromPatterns, tracker.CurGeofence)
+	}
+	return s.isDefined(s.Open) && s.matches(s.Open.To, s.Open.toPatterns, tracker.CurGeofence)
+}
+
+func (s *StateGeofence) isSeeded(tracker *Tracker) bool {
+	return tracker.CurGeofence != ""
+}
+
+func (t StateTrigger) IsTriggerDefined() bool {
+	return len(t.To) > 0
+}
+
+// indicates whether the trigger is defined for the geofence, which may also require a `from`
+func (s *StateGeofence) isDefined(t StateTrigger) bool {
+	return t.IsTriggerDefined() && (len(t.From) > 0 || !s.requireFrom)
+}
+
+// extracts the state from a payload published to the tracker's geofence topic, see extractPayloadValue
+func (s *StateGeofence) extractState(payload []byte) (string, error) {
+	return extractPayloadValue(payload, s.JSONPath)
+}
+
+// extracts a value from an mqtt payload; if a json path is defined, the payload is parsed as json and the value
+// at the dot-separated path is returned (list elements are referenced by index), otherwise the whole payload is the value
+func extractPayloadValue(payload []byte, jsonPath string) (string, error) {
+	if jsonPath == "" {
+		return strings.TrimSpace(string(payload)), nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return "", fmt.Errorf("could not unmarshal json payload, received error: %v", err)
+	}
+	for _, key := range strings.Split(jsonPath, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return "", fmt.Errorf("json path %s not found in payload, missing key %s", jsonPath, key)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("json path %s not found in payload, invalid index %s", jsonPath, key)
+			}
+			value = v[i]
+		default:
+			return "", fmt.Errorf("json path %s not found in payload, cannot find %s in a scalar value", jsonPath, key)
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64, bool:
+		return fmt.Sprint(v), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("value at json path %s is not a string, number, or boolean", jsonPath)
+	}
+}
+
+func (s *StateGeofence) parseSettings(config map[string]interface{}) error {
+	yamlData, err := yaml.Marshal(config)
+	var settings StateGeofence
+	if err != nil {
+		return fmt.Errorf("failed to marshal geofence yaml object, error: %v", err)
+	}
+	err = yaml.Unmarshal(yamlData, &settings)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal geofence yaml object, error: %v", err)
+	}
+	settings.requireFrom = s.requireFrom
+	*s = settings
+	if s.Regex {
+		for _, t := range []*StateTrigger{&s.Close, &s.Open} {
+			if t.fromPatterns, err = compileStateValues(t.From); err != nil {
+				return err
+			}
+			if t.toPatterns, err = compileStateValues(t.To); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compiles each value as a regular expression that must match the entire state
+func compileStateValues(values StateValues) ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{}
+	for _, v := range values {
+		p, err := regexp.Compile("^(?:" + v + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %s in state trigger, received error: %v", v, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// returns the state in a payload published to the tracker's geofence topic; the state is applied through ApplyFix
+// so that it's evaluated on the same goroutine as the tracker's location updates
+func (t *Tracker) ParseStatePayload(payload []byte) (string, error) {
+	state := strings.TrimSpace(string(payload))
+	if s := stateGeofence(t.GarageDoor.Geofence); s != nil {
+		return s.extractState(payload)
+	}
+	return state, nil
+}
@@ -0,0 +1,153 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/brchri/tesla-geogdo/internal/gdo/state"
+)
+
+type (
+	// outcome of the last attempt to operate a garage door
+	ActionResult struct {
+		Time    time.Time `json:"time"`
+		Action  string    `json:"action"`
+		Tracker string    `json:"tracker,omitempty"` // tracker that triggered the action; empty for commands
+		Success bool      `json:"success"`
+		Error   string    `json:"error,omitempty"` // error returned by the opener after all retries, if any
+	}
+
+	// what the service currently knows about a garage door
+	DoorStatus struct {
+		ID                string        `json:"id"`
+		Name              string        `json:"name,omitempty"`
+		Geofence          string        `json:"geofence"` // geofence type, e.g. `circular` or `polygon`
+		Opener            string        `json:"opener"`   // opener type, e.g. `ratgdo` or `http`
+		Trackers          []string      `json:"trackers"`
+		Pause             *PauseStatus  `json:"pause,omitempty"`    // pause in effect for the garage door, if any
+		OpLock            bool          `json:"op_lock"`            // whether the garage door is locked due to either cooldown or current activity
+		CooldownRemaining int           `json:"cooldown_remaining"` // seconds remaining in the cooldown following the last operation
+		LastOperation     time.Time     `json:"last_operation"`
+		LastResult        *ActionResult `json:"last_result,omitempty"`
+		Status            state.Status  `json:"status"` // last known state of the garage door as reported by its opener
+
+		Calendar []CalendarStatus `json:"calendar,omitempty"` // calendar events currently suppressing actions for the garage door, if any
+	}
+
+	// what the service currently knows about a tracker
+	TrackerStatus struct {
+		ID            string       `json:"id"`
+		Door          string       `json:"door"`
+		Location      Point        `json:"location"`
+		Distance      float64      `json:"distance,omitempty"` // distance in km from the garage, for circular geofences
+		Geofence      string       `json:"geofence,omitempty"` // current state, for state geofences
+		Membership    Membership   `json:"membership"`
+		Initialized   bool         `json:"initialized"` // whether the membership has been seeded from an update since startup
+		PendingAction string       `json:"pending_action,omitempty"`
+		Pause         *PauseStatus `json:"pause,omitempty"` // pause in effect for actions triggered by the tracker, if any
+		LastUpdate    time.Time    `json:"last_update"`
+	}
+)
+
+// records the outcome of operating the garage door for the tracker, or for a command if the tracker is nil
+func (g *GarageDoor) setLastResult(tracker *Tracker, action string, err error) {
+	result := &ActionResult{
+		Time:    time.Now(),
+		Action:  action,
+		Success: err == nil,
+	}
+	if tracker != nil {
+		result.Tracker = fmt.Sprintf("%v", tracker.ID)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	g.statusLock.Lock()
+	defer g.statusLock.Unlock()
+	g.lastResult = result
+}
+
+// records when the cooldown following the last operation ends
+func (g *GarageDoor) setCooldownUntil(t time.Time) {
+	g.statusLock.Lock()
+	defer g.statusLock.Unlock()
+	g.cooldownUntil = t
+}
+
+// returns what the service currently knows about the garage door
+func (g *GarageDoor) Status() DoorStatus {
+	s := DoorStatus{
+		ID:       g.ID,
+		Name:     g.Name,
+		Geofence: fmt.Sprintf("%v", g.GeofenceConfig["type"]),
+		Opener:   fmt.Sprintf("%v", g.OpenerConfig["type"]),
+		Trackers: []string{},
+		Pause:    g.pause(),
+		OpLock:   g.OpLock.Load(),
+		Status:   g.Opener.Status(),
+		Calendar: g.Schedule.calendarSuppressions(now()),
+	}
+	for _, t := range g.Trackers {
+		s.Trackers = append(s.Trackers, fmt.Sprintf("%v", t.ID))
+	}
+
+	g.statusLock.Lock()
+	defer g.statusLock.Unlock()
+	s.LastOperation = g.LastOperation
+	if g.lastResult != nil {
+		result := *g.lastResult
+		s.LastResult = &result
+	}
+	if remaining := time.Until(g.cooldownUntil); s.OpLock && remaining > 0 {
+		s.CooldownRemaining = int(math.Ceil(remaining.Seconds()))
+	}
+	return s
+}
+
+// returns what the service currently knows about the tracker, read between evaluations of its garage door's trackers
+func (t *Tracker) Status() TrackerStatus {
+	t.GarageDoor.evalLock.Lock()
+	defer t.GarageDoor.evalLock.Unlock()
+	return TrackerStatus{
+		ID:            fmt.Sprintf("%v", t.ID),
+		Door:          t.GarageDoor.ID,
+		Location:      t.CurrentLocation,
+		Distance:      t.CurDistance,
+		Geofence:      t.CurGeofence,
+		Membership:    t.membership(),
+		Initialized:   t.initialized,
+		PendingAction: t.PendingAction,
+		Pause:         t.pause(),
+		LastUpdate:    t.LastUpdate,
+	}
+}
+
+// returns the status of each garage door matching the door, which matches all if empty
+func DoorStatuses(door string) []DoorStatus {
+	statuses := []DoorStatus{}
+	for _, g := range GarageDoors {
+		if door != "" && g.ID != door {
+			continue
+		}
+		statuses = append(statuses, g.Status())
+	}
+	return statuses
+}
+
+// returns the status of each tracker matching the door and tracker, which match all if empty
+func TrackerStatuses(door, tracker string) []TrackerStatus {
+	statuses := []TrackerStatus{}
+	for _, g := range GarageDoors {
+		if door != "" && g.ID != door {
+			continue
+		}
+		for _, t := range g.Trackers {
+			if tracker != "" && fmt.Sprintf("%v", t.ID) != tracker {
+				continue
+			}
+			statuses = append(statuses, t.Status())
+		}
+	}
+	return statuses
+}
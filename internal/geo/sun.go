@@ -0,0 +1,123 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+type (
+	// defines conditions for open and close actions based on the position of the sun at the garage, computed locally
+	SunSettings struct {
+		Open      string   `yaml:"open,omitempty"`      // optional, `day` or `night`; only open the garage during this time
+		Close     string   `yaml:"close,omitempty"`     // optional, `day` or `night`; only close the garage during this time
+		Elevation *float64 `yaml:"elevation,omitempty"` // optional, degrees; the sun below this elevation is considered night, defaults to -0.833 (sunset), -6 is civil dusk
+		Location  Point    `yaml:"location,omitempty"`  // optional, location of the garage; defaults to the circular geofence center or the polygon geofence centroid
+	}
+)
+
+const (
+	SunDay   = "day"
+	SunNight = "night"
+
+	// elevation of the sun's center at sunrise and sunset, accounting for atmospheric refraction and the sun's radius
+	sunsetElevation = -0.833
+)
+
+// validates the sun settings and resolves the garage location from the geofence if not defined
+func (s *SunSettings) parse(g GeofenceInterface) error {
+	s.Open, s.Close = strings.ToLower(s.Open), strings.ToLower(s.Close)
+	for _, v := range []string{s.Open, s.Close} {
+		if v != "" && v != SunDay && v != SunNight {
+			return fmt.Errorf("sun condition must be %s or %s, found '%s'", SunDay, SunNight, v)
+		}
+	}
+	if !s.Location.IsPointDefined() {
+		s.Location, _ = geofenceLocation(g)
+	}
+	if (s.Open != "" || s.Close != "") && !s.Location.IsPointDefined() {
+		return fmt.Errorf("sun conditions require a location for geofences without a center, please define sun.location")
+	}
+	return nil
+}
+
+// indicates whether the action may be executed at the given time based on the position of the sun, and if not, the reason why
+func (s *SunSettings) allows(action string, t time.Time) (bool, string) {
+	required := s.Open
+	if action == ActionClose {
+		required = s.Close
+	}
+	if required == "" {
+		return true, ""
+	}
+	if s.isDark(t) != (required == SunNight) {
+		return false, fmt.Sprintf("garage door may only %s at %s, sun elevation is %.1f degrees", action, required, solarElevation(s.Location, t))
+	}
+	return true, ""
+}
+
+// indicates whether the sun is below the configured elevation at the garage
+func (s *SunSettings) isDark(t time.Time) bool {
+	elevation := sunsetElevation
+	if s.Elevation != nil {
+		elevation = *s.Elevation
+	}
+	return solarElevation(s.Location, t) < elevation
+}
+
+// returns the location of the geofence, if it has one; the center of a circular geofence, the
+// centroid of a polygon geofence, or the location of the first child of a composite geofence that has one
+func geofenceLocation(g GeofenceInterface) (Point, bool) {
+	switch g := g.(type) {
+	case *CircularGeofence:
+		return g.Center, g.Center.IsPointDefined()
+	case *PolygonGeofence:
+		for _, polygons := range []Polygons{g.Close, g.Open} {
+			if len(polygons) > 0 && len(polygons[0].Outer) > 0 {
+				return centroid(polygons[0].Outer), true
+			}
+		}
+	case *CompositeGeofence:
+		for _, child := range g.Geofences {
+			if p, ok := geofenceLocation(child); ok {
+				return p, true
+			}
+		}
+	}
+	return Point{}, false
+}
+
+// returns the average of the points, which is close enough to the centroid of a garage-sized polygon
+func centroid(points []Point) Point {
+	var c Point
+	for _, p := range points {
+		c.Lat += p.Lat
+		c.Lng += p.Lng
+	}
+	c.Lat /= float64(len(points))
+	c.Lng /= float64(len(points))
+	return c
+}
+
+// calculates the elevation of the sun in degrees above the horizon at the point and time
+// uses the low precision solar coordinates from the astronomical almanac, accurate to about 0.01 degrees
+func solarElevation(p Point, t time.Time) float64 {
+	// days since the J2000 epoch
+	n := float64(t.UTC().Unix())/86400 + 2440587.5 - 2451545.0
+
+	meanLongitude := math.Mod(280.460+0.9856474*n, 360)
+	meanAnomaly := toRadians(math.Mod(357.528+0.9856003*n, 360))
+	eclipticLongitude := toRadians(meanLongitude + 1.915*math.Sin(meanAnomaly) + 0.020*math.Sin(2*meanAnomaly))
+	obliquity := toRadians(23.439 - 0.0000004*n)
+
+	declination := math.Asin(math.Sin(obliquity) * math.Sin(eclipticLongitude))
+	rightAscension := math.Atan2(math.Cos(obliquity)*math.Sin(eclipticLongitude), math.Cos(eclipticLongitude))
+
+	siderealTime := toRadians(math.Mod(280.46061837+360.98564736629*n, 360) + p.Lng)
+	hourAngle := siderealTime - rightAscension
+
+	lat := toRadians(p.Lat)
+	elevation := math.Asin(math.Sin(lat)*math.Sin(declination) + math.Cos(lat)*math.Cos(declination)*math.Cos(hourAngle))
+	return elevation * 180 / math.Pi
+}
@@ -0,0 +1,305 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brchri/tesla-geogdo/internal/util"
+	logger "github.com/sirupsen/logrus"
+)
+
+type (
+	// a single recorded event
+	Event struct {
+		Time     time.Time `json:"time"`
+		Type     string    `json:"type"`               // one of the event type constants
+		Door     string    `json:"door"`               // garage door the event relates to
+		Tracker  string    `json:"tracker,omitempty"`  // tracker the event relates to, if any
+		Action   string    `json:"action,omitempty"`   // action the event relates to, if any, e.g. `open` or `close`
+		Lat      float64   `json:"lat,omitempty"`      // tracker location for fix events
+		Lng      float64   `json:"lng,omitempty"`      // tracker location for fix events
+		Geofence string    `json:"geofence,omitempty"` // tracker state for fix events from state geofences
+		Reason   string    `json:"reason,omitempty"`   // why an action was suppressed, or the error an opener returned
+		Success  *bool     `json:"success,omitempty"`  // whether the opener operated the garage door, for result events
+	}
+
+	// criteria for querying events; zero values match everything
+	Filter struct {
+		Door    string
+		Tracker string
+		Action  string
+		Type    string
+		Since   time.Time
+		Until   time.Time
+		Limit   int // maximum number of events to return, keeping the most recent
+	}
+
+	// retention limits for recorded events; events exceeding any limit are discarded, oldest first
+	Retention struct {
+		MaxAge       time.Duration
+		MaxEvents    int // maximum number of events other than fixes
+		MaxFixEvents int // maximum number of fix events, limited separately so frequent fixes don't push out other events
+	}
+
+	// append-only event store backed by a json lines file; all events are also kept in memory for querying
+	// events exceeding the retention limits are only discarded when the file is compacted, but are never returned
+	Store struct {
+		path      string
+		retention Retention
+		events    []Event
+		appended  int // events appended to the file since it was last compacted
+		lock      sync.Mutex
+
+		file *os.File // file events are appended to, kept open between events; nil once the store is closed
+	}
+)
+
+const (
+	TypeFix        = "fix"        // location or state update received for a tracker
+	TypeTransition = "transition" // tracker crossed a geofence boundary, producing an action
+	TypeSuppressed = "suppressed" // action was not executed, e.g. paused, cooldown, flapping, schedule, or conditions
+	TypeResult     = "result"     // outcome of operating the garage door
+	TypeCommand    = "command"    // action requested through the api
+)
+
+// minimum number of appended events before the file is compacted
+const minCompactionInterval = 100
+
+var store atomic.Pointer[Store] // store events are recorded to; events are discarded until Open is called
+
+func init() {
+	logger.SetFormatter(&util.CustomFormatter{})
+	logger.SetOutput(os.Stdout)
+	if val, ok := os.LookupEnv("DEBUG"); ok && strings.ToLower(val) == "true" {
+		logger.SetLevel(logger.DebugLevel)
+	}
+}
+
+// opens the event store at the path, loading and pruning any existing events, and records subsequent events to it
+func Open(path string, retention Retention) error {
+	s, err := NewStore(path, retention)
+	if err != nil {
+		return err
+	}
+	if prev := store.Swap(s); prev != nil {
+		prev.Close()
+	}
+	return nil
+}
+
+// stops recording events to the store opened with Open
+func Close() {
+	if s := store.Swap(nil); s != nil {
+		s.Close()
+	}
+}
+
+// records an event to the store opened with Open, if any
+func Record(e Event) {
+	s := store.Load()
+	if s == nil {
+		return
+	}
+	if err := s.Record(e); err != nil {
+		logger.Warnf("Unable to record %s event, received error: %v", e.Type, err)
+	}
+}
+
+// queries the store opened with Open; returns no events if it hasn't been opened
+func Query(f Filter) []Event {
+	s := store.Load()
+	if s == nil {
+		return []Event{}
+	}
+	return s.Query(f)
+}
+
+// returns a store at the path, loading and pruning any existing events
+func NewStore(path string, retention Retention) (*Store, error) {
+	s := &Store{path: path, retention: retention}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		if err = s.openFile(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to open history file %s, received error: %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// a partially written line from an unclean shutdown shouldn't lose the rest of the history
+			logger.Debugf("Skipping unreadable line %d in history file %s, received error: %v", line, path, err)
+			continue
+		}
+		s.events = append(s.events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read history file %s, received error: %v", path, err)
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// appends the event to the store, pruning and compacting the file once enough events have been appended
+func (s *Store) Record(e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return nil // closed
+	}
+	s.events = append(s.events, e)
+
+	s.appended++
+	if s.appended >= s.compactionInterval() {
+		return s.compact()
+	}
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+// closes the file; subsequent events are discarded
+func (s *Store) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// returns the events within the retention limits matching the filter, oldest first
+func (s *Store) Query(f Filter) []Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	events := []Event{}
+	retained := s.retained()
+	for i, e := range s.events {
+		if retained[i] && f.matches(e) {
+			events = append(events, e)
+		}
+	}
+	if f.Limit > 0 && len(events) > f.Limit {
+		events = events[len(events)-f.Limit:]
+	}
+	return events
+}
+
+func (f Filter) matches(e Event) bool {
+	return (f.Door == "" || f.Door == e.Door) &&
+		(f.Tracker == "" || f.Tracker == e.Tracker) &&
+		(f.Action == "" || f.Action == e.Action) &&
+		(f.Type == "" || f.Type == e.Type) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || !e.Time.After(f.Until))
+}
+
+// indicates which events are within the retention limits, counting fix events separately from all other events;
+// must be called with the lock held
+func (s *Store) retained() []bool {
+	var cutoff time.Time
+	if s.retention.MaxAge > 0 {
+		cutoff = time.Now().Add(-s.retention.MaxAge)
+	}
+	keep := make([]bool, len(s.events))
+	var fixes, others int
+	for i := len(s.events) - 1; i >= 0; i-- {
+		limit, count := s.retention.MaxEvents, &others
+		if s.events[i].Type == TypeFix {
+			limit, count = s.retention.MaxFixEvents, &fixes
+		}
+		*count++
+		keep[i] = (limit <= 0 || *count <= limit) && !s.events[i].Time.Before(cutoff)
+	}
+	return keep
+}
+
+// discards events exceeding the retention limits; must be called with the lock held
+func (s *Store) prune() {
+	keep := s.retained()
+	events := make([]Event, 0, len(s.events))
+	for i, e := range s.events {
+		if keep[i] {
+			events = append(events, e)
+		}
+	}
+	s.events = events
+}
+
+// opens the file for appending events; must be called with the lock held
+func (s *Store) openFile() error {
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open history file %s, received error: %v", s.path, err)
+	}
+	s.file = file
+	return nil
+}
+
+// number of events to append before the events exceeding the retention limits are discarded and the file is
+// rewritten with only the retained events; at least a tenth of the limits, so recording an event stays cheap
+func (s *Store) compactionInterval() int {
+	if interval := (s.retention.MaxEvents + s.retention.MaxFixEvents) / 10; interval > minCompactionInterval {
+		return interval
+	}
+	return minCompactionInterval
+}
+
+// discards events exceeding the retention limits and rewrites the file with only the retained events; the file is
+// replaced atomically so a crash while compacting doesn't lose the history; must be called with the lock held
+func (s *Store) compact() error {
+	s.prune()
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, e := range s.events {
+		if err = encoder.Encode(e); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	s.appended = 0
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	// reopen the file, as the previous one was replaced
+	if s.file != nil {
+		s.file.Close()
+	}
+	return s.openFile()
+}
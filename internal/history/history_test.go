@@ -0,0 +1,98 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Store(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s, err := NewStore(path, Retention{})
+	assert.Equal(t, nil, err)
+
+	now := time.Now()
+	assert.Equal(t, nil, s.Record(Event{Time: now.Add(-2 * time.Hour), Type: TypeFix, Door: "0", Tracker: "1", Lat: 1, Lng: 2}))
+	assert.Equal(t, nil, s.Record(Event{Time: now.Add(-time.Hour), Type: TypeTransition, Door: "0", Tracker: "1", Action: "close"}))
+	assert.Equal(t, nil, s.Record(Event{Type: TypeSuppressed, Door: "1", Tracker: "2", Action: "open", Reason: "garage operations are paused"}))
+
+	assert.Len(t, s.Query(Filter{}), 3)
+	assert.Len(t, s.Query(Filter{Door: "0"}), 2)
+	assert.Len(t, s.Query(Filter{Tracker: "2"}), 1)
+	assert.Len(t, s.Query(Filter{Action: "close"}), 1)
+	assert.Len(t, s.Query(Filter{Type: TypeFix}), 1)
+	assert.Len(t, s.Query(Filter{Since: now.Add(-90 * time.Minute)}), 2)
+	assert.Len(t, s.Query(Filter{Until: now.Add(-90 * time.Minute)}), 1)
+	events := s.Query(Filter{Limit: 1})
+	assert.Len(t, events, 1)
+	assert.Equal(t, TypeSuppressed, events[0].Type)
+
+	// events are loaded from the file, skipping unreadable lines, and pruned per the retention limits
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("{partial\n")
+	file.Close()
+	s, err = NewStore(path, Retention{MaxAge: 90 * time.Minute})
+	assert.Equal(t, nil, err)
+	assert.Len(t, s.Query(Filter{}), 2)
+	s, err = NewStore(path, Retention{MaxEvents: 1})
+	assert.Equal(t, nil, err)
+	assert.Len(t, s.Query(Filter{}), 1)
+	assert.Equal(t, "garage operations are paused", s.Query(Filter{})[0].Reason)
+}
+
+func Test_Store_Compaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s, err := NewStore(path, Retention{MaxEvents: 10})
+	assert.Equal(t, nil, err)
+	for i := 0; i < minCompactionInterval; i++ {
+		assert.Equal(t, nil, s.Record(Event{Type: TypeResult, Door: "0"}))
+	}
+	assert.Len(t, s.Query(Filter{}), 10)
+
+	// events beyond the limits aren't returned, but are only discarded when the file is next compacted
+	assert.Equal(t, nil, s.Record(Event{Type: TypeResult, Door: "1"}))
+	assert.Len(t, s.Query(Filter{}), 10)
+	assert.Len(t, s.events, 11)
+	assert.Equal(t, "1", s.Query(Filter{Limit: 1})[0].Door)
+
+	// events are discarded once the store is closed
+	assert.Equal(t, nil, s.Close())
+	assert.Equal(t, nil, s.Record(Event{Type: TypeResult, Door: "2"}))
+
+	// the file contains the retained events as of the last compaction, and events appended since
+	s, err = NewStore(path, Retention{})
+	assert.Equal(t, nil, err)
+	assert.Len(t, s.Query(Filter{}), 11)
+	assert.Equal(t, "1", s.Query(Filter{Limit: 1})[0].Door)
+}
+
+func Test_Store_FixRetention(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "history.jsonl"), Retention{MaxEvents: 2, MaxFixEvents: 3})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, s.Record(Event{Type: TypeTransition, Door: "0", Action: "close"}))
+	assert.Equal(t, nil, s.Record(Event{Type: TypeResult, Door: "0", Action: "close"}))
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, nil, s.Record(Event{Type: TypeFix, Door: "0", Lat: float64(i)}))
+	}
+
+	// fixes don't push out other events
+	assert.Len(t, s.Query(Filter{Type: TypeTransition}), 1)
+	assert.Len(t, s.Query(Filter{Type: TypeResult}), 1)
+	fixes := s.Query(Filter{Type: TypeFix})
+	assert.Len(t, fixes, 3)
+	assert.Equal(t, float64(7), fixes[0].Lat)
+
+	// and other events don't push out fixes
+	assert.Equal(t, nil, s.Record(Event{Type: TypeSuppressed, Door: "0", Action: "open"}))
+	assert.Len(t, s.Query(Filter{Type: TypeTransition}), 0)
+	assert.Len(t, s.Query(Filter{Type: TypeFix}), 3)
+}
+
+func Test_Record_WithoutStore(t *testing.T) {
+	Close()
+	Record(Event{Type: TypeFix})
+	assert.Equal(t, []Event{}, Query(Filter{}))
+}
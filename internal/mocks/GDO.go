@@ -2,7 +2,10 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	state "github.com/brchri/tesla-geogdo/internal/gdo/state"
+	mock "github.com/stretchr/testify/mock"
+)
 
 // GDO is an autogenerated mock type for the GDO type
 type GDO struct {
@@ -91,6 +94,47 @@ func (_c *GDO_SetGarageDoor_Call) RunAndReturn(run func(string) error) *GDO_SetG
 	return _c
 }
 
+// Status provides a mock function with given fields:
+func (_m *GDO) Status() state.Status {
+	ret := _m.Called()
+
+	var r0 state.Status
+	if rf, ok := ret.Get(0).(func() state.Status); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(state.Status)
+	}
+
+	return r0
+}
+
+// GDO_Status_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Status'
+type GDO_Status_Call struct {
+	*mock.Call
+}
+
+// Status is a helper method to define mock.On call
+func (_e *GDO_Expecter) Status() *GDO_Status_Call {
+	return &GDO_Status_Call{Call: _e.mock.On("Status")}
+}
+
+func (_c *GDO_Status_Call) Run(run func()) *GDO_Status_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *GDO_Status_Call) Return(_a0 state.Status) *GDO_Status_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *GDO_Status_Call) RunAndReturn(run func() state.Status) *GDO_Status_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // NewGDO creates a new instance of GDO. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewGDO(t interface {
@@ -14,12 +14,33 @@ type (
 		Global struct {
 			MqttSettings struct {
 				Connection MqttConnectSettings `yaml:"connection"`
+				Commands   struct {
+					Topic      string `yaml:"topic"`       // optional, topic to receive pause and resume commands on
+					PauseTopic string `yaml:"pause_topic"` // optional, topic to publish the pauses in effect to as a retained message
+				} `yaml:"commands"`
 			} `yaml:"tracker_mqtt_settings"`
 			OpCooldown int `yaml:"cooldown"`
+			State      struct {
+				File     string `yaml:"file"`     // path to persist runtime state across restarts; defaults to state.json in the config file's directory
+				MaxAge   int    `yaml:"max_age"`  // minutes after which persisted state is considered stale and discarded on startup
+				Disabled bool   `yaml:"disabled"` // disables persisting state
+			} `yaml:"state"`
+			History struct {
+				File         string `yaml:"file"`           // path to record event history; defaults to history.jsonl in the config file's directory
+				MaxAge       int    `yaml:"max_age"`        // days to retain events
+				MaxEvents    int    `yaml:"max_events"`     // maximum number of events other than fixes to retain
+				MaxFixEvents int    `yaml:"max_fix_events"` // maximum number of fix events to retain
+				Disabled     bool   `yaml:"disabled"`       // disables recording event history
+			} `yaml:"history"`
+			Api struct {
+				Commands struct {
+					Enabled bool   `yaml:"enabled"` // enables operating garage doors with the api, which requires a token
+					Token   string `yaml:"token"`   // bearer token required to send commands with the api
+				} `yaml:"commands"`
+			} `yaml:"api"`
 		} `yaml:"global"`
-		GarageDoors  []*map[string]interface{} `yaml:"garage_doors"` // this will be parsed properly later by the geo package
-		Testing      bool
-		MasterOpLock int // user-initiated lock (pause), values: 0 = disabled, <0 = indefinite pause, >0 = num seconds left on finite timeout
+		GarageDoors []*map[string]interface{} `yaml:"garage_doors"` // this will be parsed properly later by the geo package
+		Testing     bool
 	}
 
 	MqttConnectSettings struct {